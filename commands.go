@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"firefly-iii-fix-ing/internal/audit"
+	"firefly-iii-fix-ing/internal/queue"
+	"firefly-iii-fix-ing/internal/worker"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// commands contains the subcommands which can be run instead of the service.
+var commands = map[string]func(args []string) error{
+	"explain":  runExplain,
+	"backfill": runBackfill,
+	"revert":   runRevert,
+	"queue":    runQueue,
+}
+
+// commandDataDir returns the data directory from the environment.
+func commandDataDir() string {
+	if dataDir := os.Getenv(envDataDir); dataDir != "" {
+		return dataDir
+	}
+	return defaultDataDir
+}
+
+// commandFireflyOptions returns the Firefly options from the environment, which are optional for some commands.
+func commandFireflyOptions() worker.FireflyOptions {
+	return worker.FireflyOptions{
+		BaseURL:     os.Getenv(envBaseURL),
+		AccessToken: os.Getenv(envAccessToken),
+	}
+}
+
+func commandModuleOptions() worker.ModuleOptions {
+	return worker.ModuleOptions{
+		RulesDir:    os.Getenv(envModulesRulesDir),
+		AliasesPath: filepath.Join(commandDataDir(), "payee-aliases.json"),
+		AuditPath:   filepath.Join(commandDataDir(), "audit.db"),
+	}
+}
+
+// runExplain prints which modules would change a split, without writing to Firefly.
+func runExplain(args []string) error {
+	flags := flag.NewFlagSet("explain", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print traces as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: explain [-json] <transaction ID | split JSON file | ->")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one argument")
+	}
+
+	input := flags.Arg(0)
+	if _, err := strconv.Atoi(input); err != nil {
+		var content []byte
+		if input == "-" {
+			content, err = io.ReadAll(os.Stdin)
+		} else {
+			content, err = os.ReadFile(input)
+		}
+		if err != nil {
+			return err
+		}
+		input = string(content)
+	}
+
+	traces, err := worker.Explain(commandFireflyOptions(), commandModuleOptions(), input)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "    ")
+		return encoder.Encode(traces)
+	}
+	for _, trace := range traces {
+		fmt.Println(trace)
+	}
+	return nil
+}
+
+// runBackfill runs the modules over existing transactions, applying the updates if requested.
+func runBackfill(args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	start := flags.String("start", "", "only transactions on or after this date (YYYY-MM-DD)")
+	end := flags.String("end", "", "only transactions on or before this date (YYYY-MM-DD)")
+	account := flags.String("account", "", "only transactions of the account with this ID")
+	apply := flags.Bool("apply", false, "apply the updates instead of only showing them")
+	interval := flags.Duration("interval", 500*time.Millisecond, "minimum time between two updates")
+	verbose := flags.Bool("v", false, "log the output of all modules")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	fireflyOptions := commandFireflyOptions()
+	if fireflyOptions.BaseURL == "" || fireflyOptions.AccessToken == "" {
+		return fmt.Errorf("environment variables %s and %s are required", envBaseURL, envAccessToken)
+	}
+	options := worker.BackfillOptions{
+		AccountID:    *account,
+		Apply:        *apply,
+		Interval:     *interval,
+		ProgressPath: filepath.Join(commandDataDir(), "backfill-progress.json"),
+	}
+	var err error
+	if *start != "" {
+		if options.Start, err = time.Parse(time.DateOnly, *start); err != nil {
+			return fmt.Errorf("invalid start date: %w", err)
+		}
+	}
+	if *end != "" {
+		if options.End, err = time.Parse(time.DateOnly, *end); err != nil {
+			return fmt.Errorf("invalid end date: %w", err)
+		}
+	}
+	if options.Interval <= 0 {
+		return errors.New("interval needs to be positive")
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	return worker.Backfill(fireflyOptions, commandModuleOptions(), options, os.Stdout)
+}
+
+// parseCommandTime parses a date or a date with time, in local time.
+func parseCommandTime(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation(time.DateOnly, value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateTime, value, time.Local)
+}
+
+// runRevert lists or reverts the changes recorded in the audit journal.
+func runRevert(args []string) error {
+	flags := flag.NewFlagSet("revert", flag.ExitOnError)
+	transaction := flags.Int("transaction", 0, "only changes of the transaction with this ID")
+	module := flags.String("module", "", "only changes by this module")
+	since := flags.String("since", "", "only changes at or after this time (YYYY-MM-DD or YYYY-MM-DD HH:MM:SS)")
+	until := flags.String("until", "", "only changes at or before this time (YYYY-MM-DD or YYYY-MM-DD HH:MM:SS)")
+	list := flags.Bool("list", false, "only list the changes instead of reverting them")
+	verbose := flags.Bool("v", false, "log the communication with Firefly")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	filter := audit.Filter{
+		TransactionId: *transaction,
+		Module:        *module,
+		WithReverted:  *list,
+	}
+	var err error
+	if *since != "" {
+		if filter.Since, err = parseCommandTime(*since); err != nil {
+			return fmt.Errorf("invalid start time: %w", err)
+		}
+	}
+	if *until != "" {
+		if filter.Until, err = parseCommandTime(*until); err != nil {
+			return fmt.Errorf("invalid end time: %w", err)
+		}
+		if len(*until) == len(time.DateOnly) {
+			// include the whole day
+			filter.Until = filter.Until.Add(24*time.Hour - time.Nanosecond)
+		}
+	}
+	if !*list && filter == (audit.Filter{}) {
+		return errors.New("reverting all changes requires at least one filter")
+	}
+
+	moduleOptions := commandModuleOptions()
+	if *list {
+		entries, err := audit.New(moduleOptions.AuditPath).Entries(filter)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			status := ""
+			if entry.Reverted {
+				status = " (reverted)"
+			}
+			switch {
+			case entry.Created:
+				status = " created" + status
+			case entry.Deleted:
+				status = " deleted" + status
+			}
+			fmt.Printf("%s #%d/%d [%s]%s\n", entry.Time.Format(time.DateTime), entry.TransactionId, entry.JournalId, entry.Module, status)
+			if entry.Created || entry.Deleted {
+				// all fields of the journal are recorded
+				continue
+			}
+			fields := make([]string, 0, len(entry.After))
+			for field := range entry.After {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				fmt.Printf("    %s: %s -> %s\n", field, entry.Before[field], entry.After[field])
+			}
+		}
+		fmt.Printf("%d changes\n", len(entries))
+		return nil
+	}
+
+	fireflyOptions := commandFireflyOptions()
+	if fireflyOptions.BaseURL == "" || fireflyOptions.AccessToken == "" {
+		return fmt.Errorf("environment variables %s and %s are required", envBaseURL, envAccessToken)
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	return worker.Revert(fireflyOptions, moduleOptions, filter, os.Stdout)
+}
+
+// runQueue lists the dead or pending webhook deliveries, or replays dead ones.
+// Replayed deliveries are processed by the running service.
+func runQueue(args []string) error {
+	flags := flag.NewFlagSet("queue", flag.ExitOnError)
+	pending := flags.Bool("pending", false, "list the pending instead of the dead deliveries")
+	replay := flags.Bool("replay", false, "replay the dead deliveries with the given keys, or all if none are given")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: queue [-pending] [-replay [key ...]]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	q := queue.New(filepath.Join(commandDataDir(), "queue.db"))
+	if *replay {
+		replayed, err := q.Replay(flags.Args()...)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d deliveries replayed\n", replayed)
+		return nil
+	}
+
+	list := q.Dead
+	if *pending {
+		list = q.Pending
+	}
+	jobs, err := list()
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		fmt.Printf("%s received %s, %d attempts\n", job.Key, job.Received.Format(time.DateTime), job.Attempts)
+		if job.LastError != "" {
+			fmt.Printf("    last error: %s\n", job.LastError)
+		}
+		if !job.NextAttempt.IsZero() && *pending {
+			fmt.Printf("    next attempt: %s\n", job.NextAttempt.Format(time.DateTime))
+		}
+	}
+	fmt.Printf("%d deliveries\n", len(jobs))
+	return nil
+}
@@ -4,11 +4,16 @@ go 1.21
 
 require (
 	github.com/go-co-op/gocron v1.37.0
+	go.etcd.io/bbolt v1.3.9
+	go.starlark.net v0.0.0-20240123142251-f86470692795
+	golang.org/x/crypto v0.18.0
 	gopkg.in/telebot.v3 v3.2.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/google/uuid v1.4.0 // indirect
 	github.com/robfig/cron/v3 v3.0.1 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
 )
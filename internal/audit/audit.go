@@ -0,0 +1,154 @@
+// Package audit persists the values of transaction fields before and after they were changed, so changes can be reverted.
+package audit
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var bucketEntries = []byte("entries")
+
+// openTimeout limits waiting for the database lock, which is held by other processes like a running command.
+const openTimeout = 5 * time.Second
+
+// Entry records the change of fields of a single transaction journal by a single module.
+// Values are stored as sent to and received from Firefly.
+type Entry struct {
+	Id            uint64                     `json:"id"`
+	Time          time.Time                  `json:"time"`
+	TransactionId int                        `json:"transaction_id"`
+	JournalId     int                        `json:"journal_id"`
+	Module        string                     `json:"module"`
+	Before        map[string]json.RawMessage `json:"before"`
+	After         map[string]json.RawMessage `json:"after"`
+	// Created marks journals created by the change, After holds all their fields
+	Created bool `json:"created,omitempty"`
+	// Deleted marks journals deleted by the change, Before holds all their fields
+	Deleted  bool `json:"deleted,omitempty"`
+	Reverted bool `json:"reverted"`
+}
+
+// Filter selects entries. Empty fields match all entries.
+type Filter struct {
+	TransactionId int
+	Module        string
+	Since         time.Time
+	Until         time.Time
+	// WithReverted includes entries which were already reverted
+	WithReverted bool
+}
+
+func (f Filter) matches(e *Entry) bool {
+	return (f.TransactionId == 0 || e.TransactionId == f.TransactionId) &&
+		(f.Module == "" || e.Module == f.Module) &&
+		(f.Since.IsZero() || !e.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || !e.Time.After(f.Until)) &&
+		(f.WithReverted || !e.Reverted)
+}
+
+// Journal is the audit journal, stored in a bbolt database.
+// The database is only opened during each operation, so commands can access it while the service is running.
+type Journal struct {
+	path string
+}
+
+// New creates a journal stored at path.
+func New(path string) *Journal {
+	return &Journal{path: path}
+}
+
+func (j *Journal) update(fn func(b *bolt.Bucket) error) error {
+	db, err := bolt.Open(j.path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucketEntries)
+		if err != nil {
+			return err
+		}
+		return fn(b)
+	})
+}
+
+// Record adds the entries, assigning their IDs. Entries without time get the current time.
+func (j *Journal) Record(entries []Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return j.update(func(b *bolt.Bucket) error {
+		for i := range entries {
+			id, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			entries[i].Id = id
+			if entries[i].Time.IsZero() {
+				entries[i].Time = time.Now()
+			}
+			if err = put(b, &entries[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Entries returns the entries matching the filter, oldest first.
+func (j *Journal) Entries(filter Filter) ([]Entry, error) {
+	var entries []Entry
+	err := j.update(func(b *bolt.Bucket) error {
+		return b.ForEach(func(_, v []byte) error {
+			var e Entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if filter.matches(&e) {
+				entries = append(entries, e)
+			}
+			return nil
+		})
+	})
+	sort.Slice(entries, func(i, k int) bool { return entries[i].Id < entries[k].Id })
+	return entries, err
+}
+
+// MarkReverted marks the entries with the given IDs as reverted.
+func (j *Journal) MarkReverted(ids []uint64) error {
+	return j.update(func(b *bolt.Bucket) error {
+		for _, id := range ids {
+			v := b.Get(key(id))
+			if v == nil {
+				continue
+			}
+			var e Entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			e.Reverted = true
+			if err := put(b, &e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func put(b *bolt.Bucket, e *Entry) error {
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.Put(key(e.Id), v)
+}
+
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+	return k
+}
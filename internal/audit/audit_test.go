@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestJournalEntries(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	j := New(filepath.Join(t.TempDir(), "audit.db"))
+	err := j.Record([]Entry{
+		{Time: start, TransactionId: 1, JournalId: 10, Module: "ing", Before: map[string]json.RawMessage{"description": []byte(`"a"`)}, After: map[string]json.RawMessage{"description": []byte(`"b"`)}},
+		{Time: start.Add(time.Hour), TransactionId: 1, JournalId: 10, Module: "telegram"},
+		{Time: start.Add(2 * time.Hour), TransactionId: 2, JournalId: 20, Module: "ing"},
+	})
+	if err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []uint64
+	}{
+		{"all", Filter{}, []uint64{1, 2, 3}},
+		{"transaction", Filter{TransactionId: 1}, []uint64{1, 2}},
+		{"module", Filter{Module: "ing"}, []uint64{1, 3}},
+		{"since", Filter{Since: start.Add(time.Hour)}, []uint64{2, 3}},
+		{"until", Filter{Until: start.Add(time.Hour)}, []uint64{1, 2}},
+		{"combined", Filter{TransactionId: 1, Module: "ing", Until: start}, []uint64{1}},
+		{"no match", Filter{TransactionId: 3}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := j.Entries(tt.filter)
+			if err != nil {
+				t.Fatalf("Entries() error = %v", err)
+			}
+			var got []uint64
+			for _, e := range entries {
+				got = append(got, e.Id)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Entries() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Entries() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	entries, _ := j.Entries(Filter{TransactionId: 1, Module: "ing"})
+	if got := string(entries[0].After["description"]); got != `"b"` {
+		t.Errorf("After[description] = %s, want \"b\"", got)
+	}
+}
+
+func TestJournalMarkReverted(t *testing.T) {
+	j := New(filepath.Join(t.TempDir(), "audit.db"))
+	if err := j.Record([]Entry{{TransactionId: 1}, {TransactionId: 1}}); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if err := j.MarkReverted([]uint64{1, 42}); err != nil {
+		t.Fatalf("MarkReverted() error = %v", err)
+	}
+
+	entries, err := j.Entries(Filter{TransactionId: 1})
+	if err != nil {
+		t.Fatalf("Entries() error = %v", err)
+	}
+	if len(entries) != 1 || entries[0].Id != 2 {
+		t.Errorf("Entries() = %v, want only entry 2", entries)
+	}
+	entries, err = j.Entries(Filter{TransactionId: 1, WithReverted: true})
+	if err != nil {
+		t.Fatalf("Entries() error = %v", err)
+	}
+	if len(entries) != 2 || !entries[0].Reverted {
+		t.Errorf("Entries(WithReverted) = %v, want both entries, first reverted", entries)
+	}
+}
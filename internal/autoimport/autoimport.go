@@ -15,7 +15,11 @@ import (
 	"time"
 )
 
-const jsonDir = "/configs"
+const (
+	jsonDir = "/configs"
+	// importTimeout limits an import, which is answered after the importer processed it
+	importTimeout = 2 * time.Minute
+)
 
 // Manager handles imports and all handling of the results
 type Manager struct {
@@ -23,9 +27,10 @@ type Manager struct {
 	client *http.Client
 }
 
-// NewManager creates a new manager instance
-func NewManager(autoImporterURL string, autoImporterPort int, secret string) (*Manager, error) {
-	client := &http.Client{Timeout: 2 * time.Minute}
+// NewManager creates a new manager instance, sending requests with the transport.
+// If transport is nil, http.DefaultTransport is used.
+func NewManager(autoImporterURL string, autoImporterPort int, secret string, transport http.RoundTripper) (*Manager, error) {
+	client := &http.Client{Timeout: importTimeout, Transport: transport}
 	return &Manager{
 		url:    fmt.Sprintf("%s:%d/autoupload?secret=%s", autoImporterURL, autoImporterPort, secret),
 		client: client,
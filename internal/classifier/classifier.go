@@ -0,0 +1,221 @@
+// Package classifier suggests transaction categories using a naive Bayes model trained on categorized transactions.
+package classifier
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Sample holds the features of a single transaction split.
+type Sample struct {
+	Description  string
+	Counterparty string
+	Outgoing     bool
+}
+
+// LabeledSample is a Sample with a known category, used for training.
+type LabeledSample struct {
+	Sample
+	Category string
+}
+
+// Prediction is a category suggested for a sample.
+type Prediction struct {
+	Category    string
+	Probability float64
+}
+
+type categoryStats struct {
+	Documents   int            `json:"documents"`
+	TokenCounts map[string]int `json:"token_counts"`
+	TotalTokens int            `json:"total_tokens"`
+}
+
+type model struct {
+	TrainedAt  time.Time                 `json:"trained_at"`
+	Documents  int                       `json:"documents"`
+	Categories map[string]*categoryStats `json:"categories"`
+	Vocabulary map[string]bool           `json:"vocabulary"`
+}
+
+func newModel() *model {
+	return &model{
+		Categories: map[string]*categoryStats{},
+		Vocabulary: map[string]bool{},
+	}
+}
+
+// Classifier predicts categories for transactions. It is safe for concurrent use.
+type Classifier struct {
+	mu    sync.RWMutex
+	path  string
+	model *model
+}
+
+// New creates a new Classifier which persists its model at path.
+// An existing model is loaded from path.
+func New(path string) (*Classifier, error) {
+	c := &Classifier{
+		path:  path,
+		model: newModel(),
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(content, c.model); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// TrainedAt returns the time of the last full training, or the zero time if the model was never trained.
+func (c *Classifier) TrainedAt() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.model.TrainedAt
+}
+
+// Train replaces the model with one trained on the given samples.
+func (c *Classifier) Train(samples []LabeledSample) {
+	m := newModel()
+	for _, s := range samples {
+		m.learn(s.Sample, s.Category)
+	}
+	m.TrainedAt = time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.model = m
+}
+
+// Learn adds a single sample to the model.
+func (c *Classifier) Learn(s Sample, category string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.model.learn(s, category)
+}
+
+// Save persists the model.
+func (c *Classifier) Save() error {
+	c.mu.RLock()
+	content, err := json.Marshal(c.model)
+	c.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, content, 0600)
+}
+
+// Predict returns up to n categories for the sample, most probable first.
+// Returns nil if the model does not know any of the sample's features apart from the amount sign.
+func (c *Classifier) Predict(s Sample, n int) []Prediction {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var known []string
+	informative := false
+	for _, token := range tokenize(s) {
+		if c.model.Vocabulary[token] {
+			known = append(known, token)
+			informative = informative || !strings.HasPrefix(token, signPrefix)
+		}
+	}
+	if !informative {
+		return nil
+	}
+
+	vocabularySize := float64(len(c.model.Vocabulary))
+	predictions := make([]Prediction, 0, len(c.model.Categories))
+	maxLogProb := math.Inf(-1)
+	for category, stats := range c.model.Categories {
+		logProb := math.Log(float64(stats.Documents) / float64(c.model.Documents))
+		for _, token := range known {
+			logProb += math.Log((float64(stats.TokenCounts[token]) + 1) / (float64(stats.TotalTokens) + vocabularySize))
+		}
+		maxLogProb = math.Max(maxLogProb, logProb)
+		predictions = append(predictions, Prediction{Category: category, Probability: logProb})
+	}
+
+	// convert log probabilities to normalized probabilities
+	var sum float64
+	for i := range predictions {
+		predictions[i].Probability = math.Exp(predictions[i].Probability - maxLogProb)
+		sum += predictions[i].Probability
+	}
+	for i := range predictions {
+		predictions[i].Probability /= sum
+	}
+
+	sort.Slice(predictions, func(i, j int) bool {
+		if predictions[i].Probability == predictions[j].Probability {
+			return predictions[i].Category < predictions[j].Category
+		}
+		return predictions[i].Probability > predictions[j].Probability
+	})
+	if len(predictions) > n {
+		predictions = predictions[:n]
+	}
+	return predictions
+}
+
+func (m *model) learn(s Sample, category string) {
+	if category == "" {
+		return
+	}
+	stats, ok := m.Categories[category]
+	if !ok {
+		stats = &categoryStats{TokenCounts: map[string]int{}}
+		m.Categories[category] = stats
+	}
+	m.Documents++
+	stats.Documents++
+	for _, token := range tokenize(s) {
+		stats.TokenCounts[token]++
+		stats.TotalTokens++
+		m.Vocabulary[token] = true
+	}
+}
+
+const signPrefix = "sign:"
+
+// tokenize converts the sample into features.
+// Counterparty tokens are prefixed to distinguish them from description tokens,
+// numbers are dropped since they mostly consist of dates, references and terminal IDs.
+func tokenize(s Sample) []string {
+	var tokens []string
+	for _, word := range splitWords(s.Description) {
+		tokens = append(tokens, "d:"+word)
+	}
+	for _, word := range splitWords(s.Counterparty) {
+		tokens = append(tokens, "c:"+word)
+	}
+	if s.Outgoing {
+		tokens = append(tokens, signPrefix+"-")
+	} else {
+		tokens = append(tokens, signPrefix+"+")
+	}
+	return tokens
+}
+
+func splitWords(s string) []string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) < 2 || strings.IndexFunc(word, unicode.IsLetter) == -1 {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words
+}
@@ -0,0 +1,83 @@
+package classifier
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+var trainingSamples = []LabeledSample{
+	{Sample{Description: "Kartenzahlung", Counterparty: "REWE SAGT DANKE 4711", Outgoing: true}, "Lebensmittel"},
+	{Sample{Description: "Kartenzahlung", Counterparty: "REWE Markt GmbH", Outgoing: true}, "Lebensmittel"},
+	{Sample{Description: "Einkauf", Counterparty: "EDEKA Center", Outgoing: true}, "Lebensmittel"},
+	{Sample{Description: "Abschlag Strom 01/2024", Counterparty: "Stadtwerke", Outgoing: true}, "Energie"},
+	{Sample{Description: "Abschlag Gas 01/2024", Counterparty: "Stadtwerke", Outgoing: true}, "Energie"},
+	{Sample{Description: "Gehalt Januar", Counterparty: "ACME GmbH", Outgoing: false}, "Gehalt"},
+}
+
+func TestClassifierPredict(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample Sample
+		want   string
+	}{
+		{"counterparty", Sample{Description: "Kartenzahlung", Counterparty: "REWE City", Outgoing: true}, "Lebensmittel"},
+		{"description", Sample{Description: "Abschlag Strom 02/2024", Counterparty: "Unbekannt", Outgoing: true}, "Energie"},
+		{"incoming", Sample{Description: "Gehalt Februar", Counterparty: "ACME GmbH"}, "Gehalt"},
+		{"unknown", Sample{Description: "12345", Counterparty: "XYZ", Outgoing: true}, ""},
+	}
+	c, err := New(filepath.Join(t.TempDir(), "model.json"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c.Train(trainingSamples)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.Predict(tt.sample, 3)
+			if tt.want == "" {
+				if got != nil {
+					t.Errorf("Predict() = %v, want nil", got)
+				}
+				return
+			}
+			if len(got) == 0 || got[0].Category != tt.want {
+				t.Errorf("Predict() = %v, want %s first", got, tt.want)
+				return
+			}
+			var sum float64
+			for i, p := range got {
+				sum += p.Probability
+				if i > 0 && p.Probability > got[i-1].Probability {
+					t.Errorf("Predict() = %v, not sorted by probability", got)
+				}
+			}
+			if sum > 1.0001 {
+				t.Errorf("Predict() = %v, probabilities sum to %f", got, sum)
+			}
+		})
+	}
+}
+
+func TestClassifierSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.json")
+	c, err := New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c.Train(trainingSamples)
+	c.Learn(Sample{Description: "Tanken", Counterparty: "Aral", Outgoing: true}, "Auto")
+	if err = c.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := New(path)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if !loaded.TrainedAt().Equal(c.TrainedAt()) {
+		t.Errorf("TrainedAt() = %v, want %v", loaded.TrainedAt(), c.TrainedAt())
+	}
+	got := loaded.Predict(Sample{Description: "Tanken", Counterparty: "Aral", Outgoing: true}, 1)
+	if len(got) != 1 || got[0].Category != "Auto" {
+		t.Errorf("Predict() = %v, want Auto", got)
+	}
+}
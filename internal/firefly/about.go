@@ -0,0 +1,34 @@
+package firefly
+
+import (
+	"context"
+	"firefly-iii-fix-ing/internal/structs"
+	"net/http"
+)
+
+const (
+	pathAbout     = "/api/v1/about"
+	pathAboutUser = "/api/v1/about/user"
+)
+
+// About returns the version of the Firefly instance.
+func (c *Client) About(ctx context.Context) (*structs.About, error) {
+	var resp struct {
+		Data structs.About `json:"data"`
+	}
+	if err := c.Do(ctx, http.MethodGet, pathAbout, nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// CurrentUser returns the user the access token belongs to.
+func (c *Client) CurrentUser(ctx context.Context) (*structs.UserRead, error) {
+	var resp struct {
+		Data structs.UserRead `json:"data"`
+	}
+	if err := c.Do(ctx, http.MethodGet, pathAboutUser, nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
@@ -0,0 +1,137 @@
+// Package firefly is a client for the Firefly III API.
+package firefly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultTimeout limits requests of clients created without HTTP client
+const defaultTimeout = 30 * time.Second
+
+// Client calls the Firefly III API with a personal access token. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the Firefly III instance at baseURL.
+// If httpClient is nil, a client with a default timeout is used.
+func NewClient(baseURL string, token string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: defaultTimeout}
+	}
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		httpClient: httpClient,
+	}
+}
+
+// BaseURL returns the URL of the Firefly III instance, without trailing slash.
+func (c *Client) BaseURL() string {
+	return c.baseURL
+}
+
+// Error is an error response of the Firefly API.
+type Error struct {
+	StatusCode int
+	Message    string `json:"message"`
+	// Errors contains the validation errors of each field
+	Errors map[string][]string `json:"errors"`
+	// Body is the response body if it could not be decoded
+	Body string `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Unknown error with status %d ('%s')", e.StatusCode, e.Body)
+	}
+	if len(e.Errors) == 0 {
+		return e.Message
+	}
+	fields := make([]string, 0, len(e.Errors))
+	for field := range e.Errors {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	details := make([]string, len(fields))
+	for i, field := range fields {
+		details[i] = fmt.Sprintf("%s: %s", field, strings.Join(e.Errors[field], " "))
+	}
+	return fmt.Sprintf("%s (%s)", e.Message, strings.Join(details, "; "))
+}
+
+// IsNotFound returns whether err is an error response for a missing resource.
+func IsNotFound(err error) bool {
+	var responseError *Error
+	return errors.As(err, &responseError) && responseError.StatusCode == http.StatusNotFound
+}
+
+// parseError reads an error response, which Firefly sends as JSON with a message.
+func parseError(resp *http.Response) error {
+	responseError := &Error{StatusCode: resp.StatusCode}
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("status %d, could not read response body: %w", resp.StatusCode, err)
+	}
+	if err = json.Unmarshal(respBytes, responseError); err != nil || responseError.Message == "" {
+		responseError.Body = string(respBytes)
+	}
+	return responseError
+}
+
+// Do sends a request to the API path, with the body encoded as JSON unless it is nil,
+// and decodes the response into target unless it is nil.
+// Responses with other status codes than 2xx are returned as *Error.
+func (c *Client) Do(ctx context.Context, method string, path string, query url.Values, body any, target any) (err error) {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	var bodyReader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		bodyReader = bytes.NewReader(content)
+	}
+	r, err := http.NewRequestWithContext(ctx, method, endpoint, bodyReader)
+	if err != nil {
+		return err
+	}
+	r.Header.Add("Authorization", "Bearer "+c.token)
+	if body != nil {
+		r.Header.Add("Content-Type", "application/json")
+	}
+	r.Header.Add("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, resp.Body.Close())
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return parseError(resp)
+	}
+	if target == nil {
+		return nil
+	}
+	if err = json.NewDecoder(resp.Body).Decode(target); err != nil {
+		return fmt.Errorf("could not decode response of %s %s: %w", method, path, err)
+	}
+	return nil
+}
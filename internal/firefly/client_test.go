@@ -0,0 +1,72 @@
+package firefly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestIteratorReadsAllPages(t *testing.T) {
+	var pages []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		pages = append(pages, r.URL.Query().Get("page"))
+		fmt.Fprintf(w, `{"data":[{"id":"%d"},{"id":"%d"}],"meta":{"pagination":{"current_page":%d,"total_pages":3}}}`, 2*page-1, 2*page, page)
+	}))
+	defer srv.Close()
+
+	categories, err := NewClient(srv.URL+"/", "token", nil).Categories().All(context.Background())
+	if err != nil {
+		t.Fatalf("All() error = %v", err)
+	}
+	if len(categories) != 6 || categories[5].Id != "6" {
+		t.Errorf("All() = %v, want 6 categories", categories)
+	}
+	if len(pages) != 3 {
+		t.Errorf("requested pages %v, want 3", pages)
+	}
+}
+
+func TestDoError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		want       string
+		isNotFound bool
+	}{
+		{"message", http.StatusNotFound, `{"message":"Resource not found"}`, "Resource not found", true},
+		{"validation", http.StatusUnprocessableEntity, `{"message":"The given data was invalid.","errors":{"url":["The url has already been taken."],"title":["The title is required."]}}`,
+			"The given data was invalid. (title: The title is required.; url: The url has already been taken.)", false},
+		{"no json", http.StatusBadGateway, `Bad Gateway`, "Unknown error with status 502 ('Bad Gateway')", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, tt.body)
+			}))
+			defer srv.Close()
+
+			_, err := NewClient(srv.URL, "token", nil).GetTransaction(context.Background(), 1)
+			var responseError *Error
+			if !errors.As(err, &responseError) || responseError.StatusCode != tt.status {
+				t.Fatalf("GetTransaction() error = %v, want *Error with status %d", err, tt.status)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("Error() = %q, want %q", err.Error(), tt.want)
+			}
+			if IsNotFound(err) != tt.isNotFound {
+				t.Errorf("IsNotFound() = %v, want %v", IsNotFound(err), tt.isNotFound)
+			}
+		})
+	}
+}
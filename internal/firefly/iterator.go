@@ -0,0 +1,81 @@
+package firefly
+
+import (
+	"context"
+	"firefly-iii-fix-ing/internal/structs"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// pageSize is the number of items requested per page
+const pageSize = 100
+
+// Iterator reads the items of a paginated endpoint, requesting the pages when needed.
+//
+//	it := client.Categories()
+//	for it.Next(ctx) {
+//		category := it.Item()
+//	}
+//	if err := it.Err(); err != nil {
+type Iterator[T any] struct {
+	client     *Client
+	path       string
+	query      url.Values
+	page       int
+	totalPages int
+	items      []T
+	item       T
+	err        error
+}
+
+func newIterator[T any](c *Client, path string, query url.Values) *Iterator[T] {
+	if query == nil {
+		query = url.Values{}
+	}
+	return &Iterator[T]{client: c, path: path, query: query}
+}
+
+// Next advances to the next item. It returns false after the last item or if an error occurred.
+func (it *Iterator[T]) Next(ctx context.Context) bool {
+	for len(it.items) == 0 {
+		if it.err != nil || (it.page > 0 && it.page >= it.totalPages) {
+			return false
+		}
+		it.page++
+		it.query.Set("limit", strconv.Itoa(pageSize))
+		it.query.Set("page", strconv.Itoa(it.page))
+		var resp struct {
+			Data []T `json:"data"`
+			Meta struct {
+				Pagination structs.Pagination `json:"pagination"`
+			} `json:"meta"`
+		}
+		if it.err = it.client.Do(ctx, http.MethodGet, it.path, it.query, nil, &resp); it.err != nil {
+			return false
+		}
+		it.items = resp.Data
+		it.totalPages = resp.Meta.Pagination.TotalPages
+	}
+	it.item, it.items = it.items[0], it.items[1:]
+	return true
+}
+
+// Item returns the current item.
+func (it *Iterator[T]) Item() T {
+	return it.item
+}
+
+// Err returns the error which stopped the iteration, if any.
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// All returns all remaining items.
+func (it *Iterator[T]) All(ctx context.Context) ([]T, error) {
+	var items []T
+	for it.Next(ctx) {
+		items = append(items, it.Item())
+	}
+	return items, it.Err()
+}
@@ -0,0 +1,173 @@
+package firefly
+
+import (
+	"context"
+	"encoding/json"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	pathAccounts     = "/api/v1/accounts"
+	pathTransactions = "/api/v1/transactions"
+	pathCategories   = "/api/v1/categories"
+	pathTags         = "/api/v1/tags"
+	pathBudgets      = "/api/v1/budgets"
+	pathBills        = "/api/v1/bills"
+	pathWebhooks     = "/api/v1/webhooks"
+)
+
+// TransactionFilter restricts listed transactions. Empty fields match all transactions.
+type TransactionFilter struct {
+	Start time.Time
+	End   time.Time
+	// Type is one of withdrawal, deposit, transfer and others supported by Firefly
+	Type string
+}
+
+func (f TransactionFilter) query() url.Values {
+	query := url.Values{}
+	if !f.Start.IsZero() {
+		query.Set("start", f.Start.Format(time.DateOnly))
+	}
+	if !f.End.IsZero() {
+		query.Set("end", f.End.Format(time.DateOnly))
+	}
+	if f.Type != "" {
+		query.Set("type", f.Type)
+	}
+	return query
+}
+
+// Accounts lists the accounts of the given type, all accounts if it is empty.
+func (c *Client) Accounts(accountType string) *Iterator[structs.AccountRead] {
+	query := url.Values{}
+	if accountType != "" {
+		query.Set("type", accountType)
+	}
+	return newIterator[structs.AccountRead](c, pathAccounts, query)
+}
+
+// AccountTransactions lists the transactions of an account, newest first.
+func (c *Client) AccountTransactions(accountID string, filter TransactionFilter) *Iterator[structs.TransactionRead] {
+	return newIterator[structs.TransactionRead](c, fmt.Sprintf("%s/%s/transactions", pathAccounts, url.PathEscape(accountID)), filter.query())
+}
+
+// Transactions lists all transactions, newest first.
+func (c *Client) Transactions(filter TransactionFilter) *Iterator[structs.TransactionRead] {
+	return newIterator[structs.TransactionRead](c, pathTransactions, filter.query())
+}
+
+// GetTransaction returns the transaction with the given ID.
+func (c *Client) GetTransaction(ctx context.Context, id int) (*structs.TransactionRead, error) {
+	var resp struct {
+		Data structs.TransactionRead `json:"data"`
+	}
+	if err := c.Do(ctx, http.MethodGet, fmt.Sprintf("%s/%d", pathTransactions, id), nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// GetTransactionRaw returns the response document of the transaction with the given ID, for fields without typed structure.
+func (c *Client) GetTransactionRaw(ctx context.Context, id int) (json.RawMessage, error) {
+	var resp json.RawMessage
+	err := c.Do(ctx, http.MethodGet, fmt.Sprintf("%s/%d", pathTransactions, id), nil, nil, &resp)
+	return resp, err
+}
+
+// UpdateTransactionRaw updates the transaction with the given ID and returns the response document.
+// The update is usually a *structs.TransactionUpdate, use ParseTransaction to decode the response.
+// The splits in the update replace the splits of the transaction: splits without journal ID are created,
+// existing journals which are not contained in the update are deleted by Firefly.
+func (c *Client) UpdateTransactionRaw(ctx context.Context, id int, update any) (json.RawMessage, error) {
+	var resp json.RawMessage
+	err := c.Do(ctx, http.MethodPut, fmt.Sprintf("%s/%d", pathTransactions, id), nil, update, &resp)
+	return resp, err
+}
+
+// UpdateTransaction updates the transaction with the given ID like UpdateTransactionRaw and returns the updated transaction.
+func (c *Client) UpdateTransaction(ctx context.Context, id int, update *structs.TransactionUpdate) (*structs.TransactionRead, error) {
+	content, err := c.UpdateTransactionRaw(ctx, id, update)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTransaction(content)
+}
+
+// ParseTransaction decodes a response document containing a single transaction.
+func ParseTransaction(content json.RawMessage) (*structs.TransactionRead, error) {
+	var resp struct {
+		Data structs.TransactionRead `json:"data"`
+	}
+	if err := json.Unmarshal(content, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// DeleteTransaction deletes the transaction with the given ID.
+func (c *Client) DeleteTransaction(ctx context.Context, id string) error {
+	return c.Do(ctx, http.MethodDelete, fmt.Sprintf("%s/%s", pathTransactions, url.PathEscape(id)), nil, nil, nil)
+}
+
+// Categories lists all categories.
+func (c *Client) Categories() *Iterator[structs.CategoryRead] {
+	return newIterator[structs.CategoryRead](c, pathCategories, nil)
+}
+
+// Tags lists all tags.
+func (c *Client) Tags() *Iterator[structs.TagRead] {
+	return newIterator[structs.TagRead](c, pathTags, nil)
+}
+
+// Budgets lists all budgets.
+func (c *Client) Budgets() *Iterator[structs.BudgetRead] {
+	return newIterator[structs.BudgetRead](c, pathBudgets, nil)
+}
+
+// Bills lists all bills.
+func (c *Client) Bills() *Iterator[structs.BillRead] {
+	return newIterator[structs.BillRead](c, pathBills, nil)
+}
+
+// CreateBill creates a bill and returns it.
+func (c *Client) CreateBill(ctx context.Context, attributes structs.BillAttributes) (*structs.BillRead, error) {
+	var resp struct {
+		Data structs.BillRead `json:"data"`
+	}
+	if err := c.Do(ctx, http.MethodPost, pathBills, nil, attributes, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// Webhooks lists all webhooks.
+func (c *Client) Webhooks() *Iterator[structs.WebhookRead] {
+	return newIterator[structs.WebhookRead](c, pathWebhooks, nil)
+}
+
+// CreateWebhook creates a webhook and returns it, including its secret.
+func (c *Client) CreateWebhook(ctx context.Context, attributes structs.WebhookAttributes) (*structs.WebhookRead, error) {
+	var resp struct {
+		Data structs.WebhookRead `json:"data"`
+	}
+	if err := c.Do(ctx, http.MethodPost, pathWebhooks, nil, attributes, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
+
+// UpdateWebhook updates the webhook with the given ID and returns it, including its secret.
+func (c *Client) UpdateWebhook(ctx context.Context, id string, attributes structs.WebhookAttributes) (*structs.WebhookRead, error) {
+	var resp struct {
+		Data structs.WebhookRead `json:"data"`
+	}
+	if err := c.Do(ctx, http.MethodPut, fmt.Sprintf("%s/%s", pathWebhooks, url.PathEscape(id)), nil, attributes, &resp); err != nil {
+		return nil, err
+	}
+	return &resp.Data, nil
+}
@@ -0,0 +1,168 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Types of payee alias matches.
+const (
+	AliasExact  = "exact"
+	AliasPrefix = "prefix"
+	AliasRegex  = "regex"
+)
+
+// PayeeAlias maps counterparty names to a canonical name.
+// Exact and prefix matches are case-insensitive.
+type PayeeAlias struct {
+	Type    string `json:"type"`
+	Pattern string `json:"pattern"`
+	Name    string `json:"name"`
+	regex   *regexp.Regexp
+}
+
+func (a *PayeeAlias) compile() error {
+	if a.Pattern == "" || a.Name == "" {
+		return errors.New("alias needs pattern and name")
+	}
+	switch a.Type {
+	case AliasExact, AliasPrefix:
+		return nil
+	case AliasRegex:
+		var err error
+		a.regex, err = regexp.Compile(a.Pattern)
+		return err
+	default:
+		return fmt.Errorf("unknown alias type '%s'", a.Type)
+	}
+}
+
+// AliasStore holds the payee aliases, persisted as JSON. It is safe for concurrent use.
+type AliasStore struct {
+	mu      sync.RWMutex
+	path    string
+	aliases []PayeeAlias
+}
+
+// NewAliasStore loads the payee aliases from path.
+// If path is empty, aliases are only kept in memory.
+func NewAliasStore(path string) (*AliasStore, error) {
+	store := &AliasStore{path: path}
+	if path == "" {
+		return store, nil
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(content, &store.aliases); err != nil {
+		return nil, fmt.Errorf("could not parse payee aliases %s: %w", path, err)
+	}
+	for i := range store.aliases {
+		if err = store.aliases[i].compile(); err != nil {
+			return nil, fmt.Errorf("invalid payee alias #%d in %s: %w", i+1, path, err)
+		}
+	}
+	return store, nil
+}
+
+// Add adds an alias and persists the store.
+// An existing alias with the same type and pattern is replaced.
+func (s *AliasStore) Add(alias PayeeAlias) error {
+	if err := alias.compile(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	replaced := false
+	for i, existing := range s.aliases {
+		if existing.Type == alias.Type && existing.Pattern == alias.Pattern {
+			s.aliases[i] = alias
+			replaced = true
+		}
+	}
+	if !replaced {
+		s.aliases = append(s.aliases, alias)
+	}
+	if s.path == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(s.aliases, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, content, 0600)
+}
+
+// Resolve returns the canonical name for a counterparty name.
+// Exact matches take precedence over the longest prefix match, which takes precedence over the first regex match.
+func (s *AliasStore) Resolve(name string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var prefixMatch *PayeeAlias
+	var regexMatch *PayeeAlias
+	lowerName := strings.ToLower(name)
+	for i := range s.aliases {
+		alias := &s.aliases[i]
+		switch alias.Type {
+		case AliasExact:
+			if strings.EqualFold(alias.Pattern, name) {
+				return alias.Name, true
+			}
+		case AliasPrefix:
+			if strings.HasPrefix(lowerName, strings.ToLower(alias.Pattern)) && (prefixMatch == nil || len(alias.Pattern) > len(prefixMatch.Pattern)) {
+				prefixMatch = alias
+			}
+		case AliasRegex:
+			if regexMatch == nil && alias.regex.MatchString(name) {
+				regexMatch = alias
+			}
+		}
+	}
+	if prefixMatch != nil {
+		return prefixMatch.Name, true
+	}
+	if regexMatch != nil {
+		return regexMatch.Name, true
+	}
+	return "", false
+}
+
+// modulePayeeAliases replaces counterparty names by their canonical name from the alias store.
+type modulePayeeAliases struct {
+	aliases *AliasStore
+}
+
+func newModulePayeeAliases(env environment, _ json.RawMessage) (builtinProcessor, error) {
+	if env.aliases == nil {
+		return nil, errors.New("no alias store configured")
+	}
+	return &modulePayeeAliases{aliases: env.aliases}, nil
+}
+
+func (m *modulePayeeAliases) process(s *structs.WhTransactionSplit) (*structs.TransactionSplitUpdate, error) {
+	var update *structs.TransactionSplitUpdate
+	for _, counterparty := range counterpartyNames(s) {
+		name, ok := m.aliases.Resolve(counterparty)
+		if !ok || name == counterparty {
+			continue
+		}
+		if update == nil {
+			update = &structs.TransactionSplitUpdate{}
+		}
+		if counterparty == s.DestinationName {
+			update.DestinationName = name
+		} else {
+			update.SourceName = name
+		}
+	}
+	return update, nil
+}
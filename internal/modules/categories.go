@@ -0,0 +1,102 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"strings"
+)
+
+// moduleKeywordCategories assigns a category based on keywords in the description or the name of the counterparty.
+// Splits which already have a category are left untouched.
+//
+// It also runs after a rule stopped processing, as the shipped rules for ING, SEPA and PayPal stop on match,
+// so rule files referencing it can be named freely. It then matches on the cleaned description.
+type moduleKeywordCategories struct {
+	categories []keywordCategory
+}
+
+type keywordCategory struct {
+	Category string   `json:"category"`
+	Payees   []string `json:"payees"`
+	Keywords []string `json:"keywords"`
+}
+
+type keywordCategoriesOptions struct {
+	Categories []keywordCategory `json:"categories"`
+}
+
+func newModuleKeywordCategories(_ environment, options json.RawMessage) (builtinProcessor, error) {
+	var opts keywordCategoriesOptions
+	if len(options) == 0 {
+		return nil, errors.New("no categories configured")
+	}
+	if err := json.Unmarshal(options, &opts); err != nil {
+		return nil, err
+	}
+	if len(opts.Categories) == 0 {
+		return nil, errors.New("no categories configured")
+	}
+	for i := range opts.Categories {
+		c := &opts.Categories[i]
+		if c.Category == "" {
+			return nil, fmt.Errorf("category #%d has no name", i+1)
+		}
+		if len(c.Payees) == 0 && len(c.Keywords) == 0 {
+			return nil, fmt.Errorf("category '%s' has neither payees nor keywords", c.Category)
+		}
+		for j := range c.Payees {
+			c.Payees[j] = strings.ToLower(c.Payees[j])
+		}
+		for j := range c.Keywords {
+			c.Keywords[j] = strings.ToLower(c.Keywords[j])
+		}
+	}
+	return &moduleKeywordCategories{categories: opts.Categories}, nil
+}
+
+// counterpartyNames returns the names of the accounts which are not our own, depending on the transaction type.
+func counterpartyNames(s *structs.WhTransactionSplit) []string {
+	switch s.Type {
+	case "withdrawal":
+		return []string{s.DestinationName}
+	case "deposit":
+		return []string{s.SourceName}
+	case "transfer":
+		return nil
+	default:
+		return []string{s.SourceName, s.DestinationName}
+	}
+}
+
+func containsAny(s string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *moduleKeywordCategories) runsAfterStop() bool {
+	return true
+}
+
+func (m *moduleKeywordCategories) process(s *structs.WhTransactionSplit) (*structs.TransactionSplitUpdate, error) {
+	if s.CategoryName != "" {
+		return nil, nil
+	}
+	description := strings.ToLower(s.Description)
+	counterparties := counterpartyNames(s)
+	for _, c := range m.categories {
+		matched := containsAny(description, c.Keywords)
+		for _, counterparty := range counterparties {
+			matched = matched || containsAny(strings.ToLower(counterparty), c.Payees)
+		}
+		if matched {
+			return &structs.TransactionSplitUpdate{CategoryName: c.Category}, nil
+		}
+	}
+	return nil, nil
+}
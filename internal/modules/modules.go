@@ -5,157 +5,181 @@ package modules
 import (
 	"firefly-iii-fix-ing/internal/structs"
 	"log"
-	"regexp"
+	"reflect"
+	"slices"
 	"strings"
 )
 
 type fixTransactionModule interface {
-	process(s *structs.TransactionSplitUpdate) (*structs.TransactionSplitUpdate, error)
+	process(s *structs.WhTransactionSplit) (*structs.TransactionSplitUpdate, error)
 	shouldReturnOnSuccess() bool
 	name() string
 }
 
+// afterStopModule is implemented by modules which may run after a module stopped processing.
+type afterStopModule interface {
+	runsAfterStop() bool
+}
+
+func runsAfterStop(m fixTransactionModule) bool {
+	a, ok := m.(afterStopModule)
+	return ok && a.runsAfterStop()
+}
+
 // ModuleHandler provides a list of transaction handlers.
 type ModuleHandler struct {
 	moduleFuncs []fixTransactionModule
 }
 
 // NewModuleHandler creates a new ModuleHandler instance.
-func NewModuleHandler() *ModuleHandler {
+// Modules are loaded from the shipped rule files and, if not empty, the rule files in rulesDir.
+// The alias store is used by the payee alias module.
+func NewModuleHandler(rulesDir string, aliases *AliasStore) (*ModuleHandler, error) {
 	log.Println("Loading modules...")
-	moduleFuncs := []fixTransactionModule{
-		&moduleLinebreaks{},
-		&moduleIngDescriptionFormat{},
-		&modulePaypalDescriptionFormat{},
+	moduleFuncs, err := loadRules(environment{rulesDir: rulesDir, aliases: aliases})
+	if err != nil {
+		return nil, err
 	}
 	for _, m := range moduleFuncs {
-		log.Printf(">> [%s]", m.name())
+		log.Printf(">> [%s] (%s)", m.name(), describeScope(m))
 	}
-	return &ModuleHandler{moduleFuncs: moduleFuncs}
+	return &ModuleHandler{moduleFuncs: moduleFuncs}, nil
+}
+
+// Process runs the passed transaction through all configured handlers, returning the updates.
+// If a module split the transaction, there is one update per resulting split.
+func (mh *ModuleHandler) Process(s *structs.WhTransactionSplit) ([]structs.TransactionSplitUpdate, error) {
+	return mh.process(s, nil)
 }
 
-// Process runs the passed transaction through all configured handlers, returning an update.
-func (mh *ModuleHandler) Process(s *structs.WhTransactionSplit) (*structs.TransactionSplitUpdate, error) {
+// process runs the modules, recording each step in trace if not nil.
+func (mh *ModuleHandler) process(s *structs.WhTransactionSplit, trace *Trace) ([]structs.TransactionSplitUpdate, error) {
 	didUpdate := false
+	// modules see the split as modified by their predecessors
+	current := *s
 	finalUpdate := &structs.TransactionSplitUpdate{
 		JournalId:   s.JournalId,
 		Description: s.Description,
 	}
 
+	stopped := false
 	for _, module := range mh.moduleFuncs {
-		update, err := module.process(finalUpdate)
+		if stopped && !runsAfterStop(module) {
+			continue
+		}
+		update, err := module.process(&current)
+		if err == nil && update != nil && update.Splits != nil {
+			err = validateSplits(update.Splits, current.Amount)
+		}
+		step := TraceStep{Module: module.name(), Matched: err == nil && update != nil}
+		if m, ok := module.(capturingModule); ok && trace != nil {
+			step.Captures = m.captures(&current)
+		}
 		if err != nil {
 			log.Printf(">>>> ERROR: [%s]: %s", module.name(), err)
+			step.Error = err.Error()
 		} else if update == nil {
 			log.Printf(">>>> [%s]: not applicable", module.name())
 		} else {
-			mergeTransactionUpdates(update, finalUpdate, module.name())
+			before := *finalUpdate
+			mergeTransactionUpdates(update, finalUpdate, s, module.name())
+			applyUpdate(&current, finalUpdate)
+			step.Changes = diffUpdates(&before, finalUpdate)
 			didUpdate = true
-			if module.shouldReturnOnSuccess() {
-				log.Printf(">>>> [%s]: returning updated transaction", module.name())
-				return finalUpdate, nil
-			}
+			step.Stopped = !stopped && module.shouldReturnOnSuccess()
+		}
+		trace.add(step)
+		if step.Stopped {
+			log.Printf(">>>> [%s]: stopping processing of further modules", module.name())
+			stopped = true
 		}
 	}
 	if didUpdate {
-		return finalUpdate, nil
+		return expandSplits(s, finalUpdate), nil
 	}
 	return nil, nil
 }
 
-func mergeTransactionUpdates(src *structs.TransactionSplitUpdate, dst *structs.TransactionSplitUpdate, moduleName string) {
-	updatedVals := map[string]string{}
-	if src.CreditorId != "" {
-		dst.CreditorId = src.CreditorId
-		updatedVals["CreditorId"] = src.CreditorId
+// applyUpdate copies the updated fields which are visible to modules into the split.
+func applyUpdate(s *structs.WhTransactionSplit, u *structs.TransactionSplitUpdate) {
+	s.Description = u.Description
+	if u.SourceName != "" {
+		s.SourceName = u.SourceName
 	}
-	if src.MandateReference != "" {
-		dst.MandateReference = src.MandateReference
-		updatedVals["MandateReference"] = src.MandateReference
+	if u.DestinationName != "" {
+		s.DestinationName = u.DestinationName
 	}
-	if src.Description != "" {
-		dst.Description = src.Description
-		updatedVals["Description"] = src.Description
+	if u.CategoryName != "" {
+		s.CategoryName = u.CategoryName
 	}
-	for k, v := range updatedVals {
-		if v != "" {
-			log.Printf(">>>> [%s]: SET %s='%s'", moduleName, k, v)
-		}
-	}
-}
-
-// moduleIngDescriptionFormat transforms the weird transaction format from ING to human readable format.
-type moduleIngDescriptionFormat struct {
-}
-
-func (m *moduleIngDescriptionFormat) name() string {
-	return "ING description format"
-}
-
-func (m *moduleIngDescriptionFormat) shouldReturnOnSuccess() bool {
-	return true
-}
-
-var regexIngDescription = regexp.MustCompile(`^mandatereference:(.*),creditorid:(.*),remittanceinformation:(.*)$`)
-
-func (m *moduleIngDescriptionFormat) process(s *structs.TransactionSplitUpdate) (*structs.TransactionSplitUpdate, error) {
-	matches := regexIngDescription.FindStringSubmatch(s.Description)
-	if matches == nil {
-		return nil, nil
+	if u.Notes != "" {
+		s.Notes = u.Notes
 	}
-	description := matches[3]
-	if description == "" {
-		description = "n/a"
+	if u.Tags != nil {
+		s.Tags = u.Tags
 	}
-	return &structs.TransactionSplitUpdate{
-		MandateReference: matches[1],
-		CreditorId:       matches[2],
-		Description:      description,
-	}, nil
 }
 
-// moduleLinebreaks removes escaped linebreaks (semicolons)
-type moduleLinebreaks struct {
-}
-
-func (m *moduleLinebreaks) name() string {
-	return "Replace escaped linebreaks"
-}
-
-func (m *moduleLinebreaks) shouldReturnOnSuccess() bool {
-	return false
-}
-
-func (m *moduleLinebreaks) process(s *structs.TransactionSplitUpdate) (*structs.TransactionSplitUpdate, error) {
-	newDescription := strings.ReplaceAll(s.Description, "; ", "")
-	if newDescription == s.Description {
-		return nil, nil
+// appendedFields contains the JSON names of string fields whose updates are added as new line
+// to the existing value instead of replacing it.
+var appendedFields = map[string]bool{
+	"notes": true,
+}
+
+// mergeTransactionUpdates merges all non-empty fields from src into dst.
+//
+// Most fields replace the value set by previous modules.
+// Fields in appendedFields and tags are added to the value set by previous modules or, if none, the value of the original split s.
+// Tags are replaced instead if src.ReplaceTags is set.
+// Splits replace the splits set by previous modules.
+func mergeTransactionUpdates(src *structs.TransactionSplitUpdate, dst *structs.TransactionSplitUpdate, s *structs.WhTransactionSplit, moduleName string) {
+	srcValue := reflect.ValueOf(src).Elem()
+	dstValue := reflect.ValueOf(dst).Elem()
+	splitValue := reflect.ValueOf(s).Elem()
+	for i := 0; i < srcValue.NumField(); i++ {
+		if srcValue.Field(i).Kind() != reflect.String {
+			continue
+		}
+		v := srcValue.Field(i).String()
+		if v == "" {
+			continue
+		}
+		field := srcValue.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if appendedFields[name] {
+			existing := dstValue.Field(i).String()
+			if existing == "" {
+				existing = splitValue.FieldByName(field.Name).String()
+			}
+			if strings.Contains(existing, v) {
+				v = existing
+			} else if existing != "" {
+				v = existing + "\n" + v
+			}
+		}
+		dstValue.Field(i).SetString(v)
+		log.Printf(">>>> [%s]: SET %s='%s'", moduleName, field.Name, v)
 	}
-	return &structs.TransactionSplitUpdate{
-		Description: newDescription,
-	}, nil
-}
-
-// modulePaypalDescriptionFormat converts PayPal's description format to a human readable format.
-type modulePaypalDescriptionFormat struct {
-}
 
-func (m *modulePaypalDescriptionFormat) name() string {
-	return "PayPal description format"
-}
-
-func (m *modulePaypalDescriptionFormat) shouldReturnOnSuccess() bool {
-	return true
-}
-
-var regexPaypalDescription = regexp.MustCompile(`^\d+ PP\.\d{4}\.PP \. .+, Ihr (Einkauf bei.+)$`)
+	if len(src.Tags) > 0 {
+		tags := src.Tags
+		if !src.ReplaceTags {
+			tags = dst.Tags
+			if tags == nil {
+				tags = slices.Clone(s.Tags)
+			}
+			for _, tag := range src.Tags {
+				if !slices.Contains(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+		}
+		dst.Tags = tags
+		log.Printf(">>>> [%s]: SET Tags='%s'", moduleName, strings.Join(tags, ", "))
+	}
 
-func (m *modulePaypalDescriptionFormat) process(s *structs.TransactionSplitUpdate) (*structs.TransactionSplitUpdate, error) {
-	matches := regexPaypalDescription.FindStringSubmatch(s.Description)
-	if matches == nil {
-		return nil, nil
+	if src.Splits != nil {
+		dst.Splits = src.Splits
+		log.Printf(">>>> [%s]: SET Splits=%d", moduleName, len(src.Splits))
 	}
-	return &structs.TransactionSplitUpdate{
-		Description: "PayPal: " + matches[1],
-	}, nil
 }
@@ -2,13 +2,30 @@ package modules
 
 import (
 	"firefly-iii-fix-ing/internal/structs"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
 
+func findDefaultRule(t *testing.T, name string) fixTransactionModule {
+	t.Helper()
+	modules, err := loadRules(environment{aliases: &AliasStore{}})
+	if err != nil {
+		t.Fatalf("loadRules() error = %v", err)
+	}
+	for _, m := range modules {
+		if m.name() == name {
+			return m
+		}
+	}
+	t.Fatalf("default rule '%s' not found", name)
+	return nil
+}
+
 func TestModuleIngDescriptionFormatProcess(t *testing.T) {
 	type args struct {
-		s *structs.TransactionSplitUpdate
+		s *structs.WhTransactionSplit
 	}
 	tests := []struct {
 		name    string
@@ -18,32 +35,32 @@ func TestModuleIngDescriptionFormatProcess(t *testing.T) {
 	}{
 		{
 			"all fields filled",
-			args{s: &structs.TransactionSplitUpdate{Description: "mandatereference:mRef,creditorid:credId,remittanceinformation:RemInf"}},
-			&structs.TransactionSplitUpdate{Description: "RemInf", CreditorId: "credId", MandateReference: "mRef"},
+			args{s: &structs.WhTransactionSplit{Description: "mandatereference:mRef,creditorid:credId,remittanceinformation:RemInf"}},
+			&structs.TransactionSplitUpdate{Description: "RemInf", SepaCi: "credId", MandateReference: "mRef"},
 			false,
 		},
 		{
 			"one field empty",
-			args{s: &structs.TransactionSplitUpdate{Description: "mandatereference:mRef,creditorid:,remittanceinformation:RemInf"}},
+			args{s: &structs.WhTransactionSplit{Description: "mandatereference:mRef,creditorid:,remittanceinformation:RemInf"}},
 			&structs.TransactionSplitUpdate{Description: "RemInf", MandateReference: "mRef"},
 			false,
 		},
 		{
 			"description placeholder",
-			args{s: &structs.TransactionSplitUpdate{Description: "mandatereference:mRef,creditorid:credId,remittanceinformation:"}},
-			&structs.TransactionSplitUpdate{Description: "n/a", CreditorId: "credId", MandateReference: "mRef"},
+			args{s: &structs.WhTransactionSplit{Description: "mandatereference:mRef,creditorid:credId,remittanceinformation:"}},
+			&structs.TransactionSplitUpdate{Description: "n/a", SepaCi: "credId", MandateReference: "mRef"},
 			false,
 		},
 		{
 			"all fields empty",
-			args{s: &structs.TransactionSplitUpdate{Description: "mandatereference:,creditorid:,remittanceinformation:"}},
+			args{s: &structs.WhTransactionSplit{Description: "mandatereference:,creditorid:,remittanceinformation:"}},
 			&structs.TransactionSplitUpdate{Description: "n/a"},
 			false,
 		},
 	}
+	m := findDefaultRule(t, "ING description format")
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			m := &moduleIngDescriptionFormat{}
 			got, err := m.process(tt.args.s)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("process() error = %v, wantErr %v", err, tt.wantErr)
@@ -55,3 +72,727 @@ func TestModuleIngDescriptionFormatProcess(t *testing.T) {
 		})
 	}
 }
+
+func TestModuleHandlerProcess(t *testing.T) {
+	tests := []struct {
+		name string
+		s    *structs.WhTransactionSplit
+		want []structs.TransactionSplitUpdate
+	}{
+		{
+			"no rule applicable",
+			&structs.WhTransactionSplit{JournalId: 1, Description: "Miete"},
+			nil,
+		},
+		{
+			"linebreaks",
+			&structs.WhTransactionSplit{JournalId: 1, Description: "Miete; Januar"},
+			[]structs.TransactionSplitUpdate{{JournalId: 1, Description: "MieteJanuar"}},
+		},
+		{
+			"linebreaks before ING",
+			&structs.WhTransactionSplit{JournalId: 2, Description: "mandatereference:mRef,creditorid:credId,remittanceinformation:Rem; Inf"},
+			[]structs.TransactionSplitUpdate{{JournalId: 2, Description: "RemInf", SepaCi: "credId", MandateReference: "mRef"}},
+		},
+		{
+			"PayPal",
+			&structs.WhTransactionSplit{JournalId: 3, Description: "1234567890 PP.1234.PP . Shop GmbH, Ihr Einkauf bei Shop GmbH"},
+			[]structs.TransactionSplitUpdate{{JournalId: 3, Description: "PayPal: Einkauf bei Shop GmbH"}},
+		},
+	}
+	mh, err := NewModuleHandler("", &AliasStore{})
+	if err != nil {
+		t.Fatalf("NewModuleHandler() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mh.Process(tt.s)
+			if err != nil {
+				t.Errorf("Process() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Process() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRuleCompile(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       rule
+		wantErr bool
+	}{
+		{
+			"valid",
+			rule{Name: "valid", Match: map[string]string{"description": "^(?P<x>.*)$"}, Set: map[string]string{"description": "${x}"}},
+			false,
+		},
+		{
+			"missing name",
+			rule{Match: map[string]string{"description": ".*"}},
+			true,
+		},
+		{
+			"no match",
+			rule{Name: "no match"},
+			true,
+		},
+		{
+			"unknown match field",
+			rule{Name: "unknown match field", Match: map[string]string{"amount": ".*"}},
+			true,
+		},
+		{
+			"invalid regex",
+			rule{Name: "invalid regex", Match: map[string]string{"description": "("}},
+			true,
+		},
+		{
+			"unknown set field",
+			rule{Name: "unknown set field", Match: map[string]string{"description": ".*"}, Set: map[string]string{"foo": "bar"}},
+			true,
+		},
+		{
+			"unknown capture group",
+			rule{Name: "unknown capture group", Match: map[string]string{"description": ".*"}, Set: map[string]string{"description": "${x}"}},
+			true,
+		},
+		{
+			"unknown builtin",
+			rule{Name: "unknown builtin", Builtin: "foo"},
+			true,
+		},
+		{
+			"builtin with match",
+			rule{Name: "builtin with match", Builtin: "sepa-tags", Match: map[string]string{"description": ".*"}},
+			true,
+		},
+		{
+			"replace without match",
+			rule{Name: "replace without match", Match: map[string]string{"description": ".*"}, Replace: map[string]string{"source_name": ""}},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.r.compile(environment{}); (err != nil) != tt.wantErr {
+				t.Errorf("compile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRulesYAML(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"10-ing.yaml": `
+- name: ING from YAML
+  match:
+    description: '^ing:(?P<description>.*)$'
+  stop_on_match: true
+`,
+		"30-custom.yml": `
+- name: custom
+  match:
+    description: '^(?P<description>.*) Karte$'
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	modules, err := loadRules(environment{rulesDir: dir, aliases: &AliasStore{}})
+	if err != nil {
+		t.Fatalf("loadRules() error = %v", err)
+	}
+	var got []string
+	for _, m := range modules {
+		got = append(got, m.name())
+	}
+	want := []string{"Replace escaped linebreaks", "Payee aliases", "ING from YAML", "SEPA remittance tags", "PayPal description format", "custom"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadRules() = %v, want %v", got, want)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, "40-invalid.yaml"), []byte("- name: [unclosed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = loadRules(environment{rulesDir: dir, aliases: &AliasStore{}}); err == nil {
+		t.Errorf("loadRules() with invalid YAML error = nil, want error")
+	}
+}
+
+func TestModuleScriptProcess(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		s       *structs.WhTransactionSplit
+		want    *structs.TransactionSplitUpdate
+		wantErr bool
+	}{
+		{
+			"applicable",
+			`
+def process(t):
+    if t.amount < 0 and t.source_name == "Joint":
+        return {"description": t.description.split(" Karte ")[0]}
+    return None
+`,
+			&structs.WhTransactionSplit{Amount: "-12.50", SourceName: "Joint", Description: "REWE Karte 1234"},
+			&structs.TransactionSplitUpdate{Description: "REWE"},
+			false,
+		},
+		{
+			"not applicable",
+			`
+def process(t):
+    if t.amount < 0 and t.source_name == "Joint":
+        return {"description": t.description.split(" Karte ")[0]}
+    return None
+`,
+			&structs.WhTransactionSplit{Amount: "12.50", SourceName: "Joint", Description: "REWE Karte 1234"},
+			nil,
+			false,
+		},
+		{
+			"existing tags",
+			`
+def process(t):
+    if "import" not in t.tags:
+        return None
+    return {"tags": [tag for tag in t.tags if tag != "import"] + ["imported"], "replace_tags": True}
+`,
+			&structs.WhTransactionSplit{Tags: []string{"import", "paypal"}},
+			&structs.TransactionSplitUpdate{Tags: []string{"paypal", "imported"}, ReplaceTags: true},
+			false,
+		},
+		{
+			"unknown field",
+			`
+def process(t):
+    return {"amount": "1"}
+`,
+			&structs.WhTransactionSplit{},
+			nil,
+			true,
+		},
+		{
+			"splits",
+			`
+def process(t):
+    return {"tags": ["split"], "splits": [
+        {"amount": 7.5, "category_name": "Groceries"},
+        {"amount": "2.50", "category_name": "Household", "description": "Detergent"},
+    ]}
+`,
+			&structs.WhTransactionSplit{Amount: "10.00"},
+			&structs.TransactionSplitUpdate{Tags: []string{"split"}, Splits: []structs.TransactionSplitUpdate{
+				{Amount: "7.50", CategoryName: "Groceries"},
+				{Amount: "2.50", CategoryName: "Household", Description: "Detergent"},
+			}},
+			false,
+		},
+		{
+			"split without amount",
+			`
+def process(t):
+    return {"splits": [{"category_name": "Groceries"}, {"amount": 1}]}
+`,
+			&structs.WhTransactionSplit{Amount: "10.00"},
+			nil,
+			true,
+		},
+		{
+			"invalid return type",
+			`
+def process(t):
+    return "foo"
+`,
+			&structs.WhTransactionSplit{},
+			nil,
+			true,
+		},
+		{
+			"step limit",
+			`
+def process(t):
+    for i in range(100000000):
+        pass
+`,
+			&structs.WhTransactionSplit{},
+			nil,
+			true,
+		},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "script.star")
+			if err := os.WriteFile(path, []byte(tt.script), 0600); err != nil {
+				t.Fatal(err)
+			}
+			m, err := (&rule{Name: tt.name, Script: "script.star"}).compile(environment{rulesDir: dir})
+			if err != nil {
+				t.Fatalf("compile() error = %v", err)
+			}
+			got, err := m.process(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("process() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("process() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModuleSepaTagsProcess(t *testing.T) {
+	tests := []struct {
+		name string
+		s    *structs.WhTransactionSplit
+		want *structs.TransactionSplitUpdate
+	}{
+		{
+			"all tags",
+			&structs.WhTransactionSplit{Description: "EREF+E2E-123 KREF+K-1 MREF+M-456 CRED+DE98ZZZ09999999999 SVWZ+Rechnung 4711 ABWA+Max Mustermann ABWE+Erika Musterfrau"},
+			&structs.TransactionSplitUpdate{
+				Description:      "Rechnung 4711",
+				MandateReference: "M-456",
+				SepaCi:           "DE98ZZZ09999999999",
+				SepaCtId:         "E2E-123",
+				Notes:            "Ultimate payer: Max Mustermann\nUltimate payee: Erika Musterfrau\nCustomer reference: K-1",
+			},
+		},
+		{
+			"not provided",
+			&structs.WhTransactionSplit{Description: "EREF+NOTPROVIDED SVWZ+Miete Januar"},
+			&structs.TransactionSplitUpdate{Description: "Miete Januar"},
+		},
+		{
+			"no remittance information",
+			&structs.WhTransactionSplit{Description: "EREF+123 MREF+456"},
+			&structs.TransactionSplitUpdate{Description: "n/a", SepaCtId: "123", MandateReference: "456"},
+		},
+		{
+			"tags without separator",
+			&structs.WhTransactionSplit{Description: "EREF+123MREF+456SVWZ+Beitrag"},
+			&structs.TransactionSplitUpdate{Description: "Beitrag", SepaCtId: "123", MandateReference: "456"},
+		},
+		{
+			"no tags",
+			&structs.WhTransactionSplit{Description: "Miete Januar"},
+			nil,
+		},
+		{
+			"tag not at beginning",
+			&structs.WhTransactionSplit{Description: "Danke SVWZ+Miete"},
+			nil,
+		},
+	}
+	m := &moduleSepaTags{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.process(tt.s)
+			if err != nil {
+				t.Errorf("process() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("process() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRulePacks(t *testing.T) {
+	tests := []struct {
+		name string
+		pack string
+		s    *structs.WhTransactionSplit
+		want []structs.TransactionSplitUpdate
+	}{
+		{
+			"DKB card payment",
+			"dkb",
+			&structs.WhTransactionSplit{SourceName: "Giro", DestinationName: "REWE SAGT DANKE 4711", Description: "VISA Debitkartenumsatz vom 02.01.2024"},
+			[]structs.TransactionSplitUpdate{{Description: "Kartenzahlung REWE SAGT DANKE", DestinationName: "REWE SAGT DANKE"}},
+		},
+		{
+			"DKB value date",
+			"dkb",
+			&structs.WhTransactionSplit{SourceName: "Giro", Description: "Gehalt Januar, Wertstellung: 31.01.2024"},
+			[]structs.TransactionSplitUpdate{{Description: "Gehalt Januar"}},
+		},
+		{
+			"Sparkasse girocard",
+			"sparkasse",
+			&structs.WhTransactionSplit{SourceName: "Giro", DestinationName: "EDEKA CENTER 12345678", Description: "2024-01-02T12:34 Debitk.1 2027-12 Kartenzahlung girocard"},
+			[]structs.TransactionSplitUpdate{{Description: "Kartenzahlung EDEKA CENTER", DestinationName: "EDEKA CENTER"}},
+		},
+		{
+			"Comdirect card payment",
+			"comdirect",
+			&structs.WhTransactionSplit{SourceName: "Giro", DestinationName: "Visa", Description: "Auftraggeber: REWE Buchungstext: REWE SAGT DANKE 12345//Berlin/DE 2024-01-02T12:34:56 KFN 0 VJ 2512 Kartenzahlung"},
+			[]structs.TransactionSplitUpdate{{Description: "Kartenzahlung REWE SAGT DANKE, Berlin", DestinationName: "REWE SAGT DANKE"}},
+		},
+		{
+			"N26 card payment",
+			"n26",
+			&structs.WhTransactionSplit{SourceName: "Giro", DestinationName: "AMZN Mktp DE 0815", Description: "-"},
+			[]structs.TransactionSplitUpdate{{Description: "Kartenzahlung AMZN Mktp DE", DestinationName: "AMZN Mktp DE"}},
+		},
+		{
+			"Revolut card payment",
+			"revolut",
+			&structs.WhTransactionSplit{SourceName: "Giro", Description: "Card Payment to Lidl 4711"},
+			[]structs.TransactionSplitUpdate{{Description: "Kartenzahlung Lidl", DestinationName: "Lidl"}},
+		},
+		{
+			"Revolut top-up",
+			"revolut",
+			&structs.WhTransactionSplit{DestinationName: "Giro", Description: "Top-Up by *1234"},
+			[]structs.TransactionSplitUpdate{{Description: "Aufladung mit Karte *1234"}},
+		},
+		{
+			"other account",
+			"dkb",
+			&structs.WhTransactionSplit{SourceName: "Kreditkarte", DestinationName: "REWE SAGT DANKE 4711", Description: "VISA Debitkartenumsatz"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			modules, err := loadPack(environment{}, tt.pack)
+			if err != nil {
+				t.Fatalf("loadPack() error = %v", err)
+			}
+			got, err := (&ModuleHandler{moduleFuncs: withAccountScope(modules, []string{"Giro"})}).Process(tt.s)
+			if err != nil {
+				t.Errorf("Process() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Process() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModuleAccountScopeInScope(t *testing.T) {
+	tests := []struct {
+		name string
+		s    *structs.WhTransactionSplit
+		want bool
+	}{
+		{"source name", &structs.WhTransactionSplit{SourceName: "ING Giro", DestinationName: "REWE"}, true},
+		{"destination name", &structs.WhTransactionSplit{SourceName: "Arbeitgeber", DestinationName: "ING Giro"}, true},
+		{"source ID", &structs.WhTransactionSplit{SourceId: "42", SourceName: "Kreditkarte"}, true},
+		{"destination ID", &structs.WhTransactionSplit{DestinationId: "42", DestinationName: "Kreditkarte"}, true},
+		{"other account", &structs.WhTransactionSplit{SourceId: "7", SourceName: "Kreditkarte", DestinationName: "REWE"}, false},
+	}
+	m := &moduleAccountScope{accounts: []string{"ING Giro", "42"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.inScope(tt.s); got != tt.want {
+				t.Errorf("inScope() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModuleKeywordCategoriesProcess(t *testing.T) {
+	tests := []struct {
+		name string
+		s    *structs.WhTransactionSplit
+		want *structs.TransactionSplitUpdate
+	}{
+		{
+			"payee",
+			&structs.WhTransactionSplit{Type: "withdrawal", SourceName: "Giro", DestinationName: "REWE SAGT DANKE 4711", Description: "Kartenzahlung"},
+			&structs.TransactionSplitUpdate{CategoryName: "Lebensmittel"},
+		},
+		{
+			"keyword",
+			&structs.WhTransactionSplit{Type: "withdrawal", SourceName: "Giro", DestinationName: "Stadtwerke", Description: "Abschlag STROM Januar"},
+			&structs.TransactionSplitUpdate{CategoryName: "Energie"},
+		},
+		{
+			"payee of deposit is source",
+			&structs.WhTransactionSplit{Type: "deposit", SourceName: "Giro", DestinationName: "REWE", Description: "Erstattung"},
+			nil,
+		},
+		{
+			"category already set",
+			&structs.WhTransactionSplit{Type: "withdrawal", DestinationName: "REWE", CategoryName: "Haushalt"},
+			nil,
+		},
+		{
+			"no match",
+			&structs.WhTransactionSplit{Type: "withdrawal", DestinationName: "Amazon", Description: "Bestellung"},
+			nil,
+		},
+	}
+	m, err := newModuleKeywordCategories(environment{}, []byte(`{"categories": [
+		{"category": "Lebensmittel", "payees": ["rewe", "Edeka"]},
+		{"category": "Energie", "keywords": ["Strom"]}
+	]}`))
+	if err != nil {
+		t.Fatalf("newModuleKeywordCategories() error = %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.process(tt.s)
+			if err != nil {
+				t.Errorf("process() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("process() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModuleKeywordCategoriesAfterStop(t *testing.T) {
+	dir := t.TempDir()
+	content := `[{"name": "categories", "builtin": "keyword-categories", "options": {"categories": [{"category": "Rent", "keywords": ["miete"]}]}}]`
+	if err := os.WriteFile(filepath.Join(dir, "50-categories.json"), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	mh, err := NewModuleHandler(dir, &AliasStore{})
+	if err != nil {
+		t.Fatalf("NewModuleHandler() error = %v", err)
+	}
+	got, err := mh.Process(&structs.WhTransactionSplit{JournalId: 1, Description: "mandatereference:mRef,creditorid:,remittanceinformation:Miete Januar"})
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	want := []structs.TransactionSplitUpdate{{JournalId: 1, Description: "Miete Januar", MandateReference: "mRef", CategoryName: "Rent"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Process() got = %v, want %v", got, want)
+	}
+}
+
+func TestModulePayeeAliasesProcess(t *testing.T) {
+	tests := []struct {
+		name string
+		s    *structs.WhTransactionSplit
+		want *structs.TransactionSplitUpdate
+	}{
+		{
+			"exact",
+			&structs.WhTransactionSplit{Type: "withdrawal", SourceName: "Giro", DestinationName: "rewe.de"},
+			&structs.TransactionSplitUpdate{DestinationName: "REWE"},
+		},
+		{
+			"prefix",
+			&structs.WhTransactionSplit{Type: "withdrawal", SourceName: "Giro", DestinationName: "REWE SAGT DANKE 4711"},
+			&structs.TransactionSplitUpdate{DestinationName: "REWE"},
+		},
+		{
+			"longest prefix",
+			&structs.WhTransactionSplit{Type: "withdrawal", SourceName: "Giro", DestinationName: "REWE Markt GmbH Berlin"},
+			&structs.TransactionSplitUpdate{DestinationName: "REWE Markt"},
+		},
+		{
+			"regex",
+			&structs.WhTransactionSplit{Type: "withdrawal", SourceName: "Giro", DestinationName: "AMZN Mktp DE*1A2B3C"},
+			&structs.TransactionSplitUpdate{DestinationName: "Amazon"},
+		},
+		{
+			"deposit",
+			&structs.WhTransactionSplit{Type: "deposit", SourceName: "AMAZON PAYMENTS EUROPE", DestinationName: "Giro"},
+			&structs.TransactionSplitUpdate{SourceName: "Amazon"},
+		},
+		{
+			"already canonical",
+			&structs.WhTransactionSplit{Type: "withdrawal", SourceName: "Giro", DestinationName: "Amazon"},
+			nil,
+		},
+		{
+			"unknown",
+			&structs.WhTransactionSplit{Type: "withdrawal", SourceName: "Giro", DestinationName: "Bäckerei"},
+			nil,
+		},
+	}
+	store, err := NewAliasStore(filepath.Join(t.TempDir(), "aliases.json"))
+	if err != nil {
+		t.Fatalf("NewAliasStore() error = %v", err)
+	}
+	for _, alias := range []PayeeAlias{
+		{Type: AliasExact, Pattern: "REWE.DE", Name: "REWE"},
+		{Type: AliasPrefix, Pattern: "rewe ", Name: "REWE"},
+		{Type: AliasPrefix, Pattern: "REWE Markt", Name: "REWE Markt"},
+		{Type: AliasRegex, Pattern: `(?i)^(AMZN|AMAZON)\b`, Name: "Amazon"},
+	} {
+		if err = store.Add(alias); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	// reload to verify persistence
+	store, err = NewAliasStore(store.path)
+	if err != nil {
+		t.Fatalf("NewAliasStore() error = %v", err)
+	}
+	m := &modulePayeeAliases{aliases: store}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := m.process(tt.s)
+			if err != nil {
+				t.Errorf("process() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("process() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeTransactionUpdates(t *testing.T) {
+	tests := []struct {
+		name string
+		s    *structs.WhTransactionSplit
+		dst  *structs.TransactionSplitUpdate
+		src  *structs.TransactionSplitUpdate
+		want *structs.TransactionSplitUpdate
+	}{
+		{
+			"string fields replace",
+			&structs.WhTransactionSplit{Description: "old"},
+			&structs.TransactionSplitUpdate{Description: "first", BudgetName: "Food"},
+			&structs.TransactionSplitUpdate{Description: "second", BillName: "Rent"},
+			&structs.TransactionSplitUpdate{Description: "second", BudgetName: "Food", BillName: "Rent"},
+		},
+		{
+			"notes append to existing notes",
+			&structs.WhTransactionSplit{Notes: "imported"},
+			&structs.TransactionSplitUpdate{},
+			&structs.TransactionSplitUpdate{Notes: "Ultimate payee: Foo"},
+			&structs.TransactionSplitUpdate{Notes: "imported\nUltimate payee: Foo"},
+		},
+		{
+			"notes not duplicated",
+			&structs.WhTransactionSplit{Notes: "imported"},
+			&structs.TransactionSplitUpdate{Notes: "imported\nUltimate payee: Foo"},
+			&structs.TransactionSplitUpdate{Notes: "Ultimate payee: Foo"},
+			&structs.TransactionSplitUpdate{Notes: "imported\nUltimate payee: Foo"},
+		},
+		{
+			"tags added to existing tags",
+			&structs.WhTransactionSplit{Tags: []string{"a", "b"}},
+			&structs.TransactionSplitUpdate{},
+			&structs.TransactionSplitUpdate{Tags: []string{"b", "c"}},
+			&structs.TransactionSplitUpdate{Tags: []string{"a", "b", "c"}},
+		},
+		{
+			"tags replaced",
+			&structs.WhTransactionSplit{Tags: []string{"a", "b"}},
+			&structs.TransactionSplitUpdate{Tags: []string{"a", "b", "x"}},
+			&structs.TransactionSplitUpdate{Tags: []string{"c"}, ReplaceTags: true},
+			&structs.TransactionSplitUpdate{Tags: []string{"c"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mergeTransactionUpdates(tt.src, tt.dst, tt.s, "test")
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Errorf("mergeTransactionUpdates() got = %+v, want %+v", tt.dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSplits(t *testing.T) {
+	tests := []struct {
+		name    string
+		splits  []structs.TransactionSplitUpdate
+		amount  string
+		wantErr bool
+	}{
+		{"valid", []structs.TransactionSplitUpdate{{Amount: "7.5"}, {Amount: "2.50"}}, "10.000000000000", false},
+		{"negative original", []structs.TransactionSplitUpdate{{Amount: "7.5"}, {Amount: "2.50"}}, "-10.00", false},
+		{"wrong sum", []structs.TransactionSplitUpdate{{Amount: "7.5"}, {Amount: "2.49"}}, "10.00", true},
+		{"single split", []structs.TransactionSplitUpdate{{Amount: "10.00"}}, "10.00", true},
+		{"zero amount", []structs.TransactionSplitUpdate{{Amount: "10.00"}, {Amount: "0"}}, "10.00", true},
+		{"invalid amount", []structs.TransactionSplitUpdate{{Amount: "7,50"}, {Amount: "2.50"}}, "10.00", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSplits(tt.splits, tt.amount); (err != nil) != tt.wantErr {
+				t.Errorf("validateSplits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpandSplits(t *testing.T) {
+	s := &structs.WhTransactionSplit{
+		JournalId:       1,
+		Type:            "withdrawal",
+		Date:            "2024-01-02T00:00:00+01:00",
+		Amount:          "10.00",
+		Description:     "Amazon",
+		SourceId:        "1",
+		SourceName:      "Giro",
+		DestinationId:   "2",
+		DestinationName: "AMZN Mktp DE",
+		Tags:            []string{"import"},
+	}
+	u := &structs.TransactionSplitUpdate{
+		JournalId:       1,
+		Description:     "Amazon",
+		DestinationName: "Amazon",
+		Splits: []structs.TransactionSplitUpdate{
+			{Amount: "7.50", Description: "Book", Tags: []string{"books"}},
+			{Amount: "2.50", CategoryName: "Household"},
+		},
+	}
+	want := []structs.TransactionSplitUpdate{
+		{JournalId: 1, Amount: "7.50", Description: "Book", DestinationName: "Amazon", Tags: []string{"import", "books"}},
+		{Type: "withdrawal", Date: "2024-01-02T00:00:00+01:00", Amount: "2.50", Description: "Amazon", CategoryName: "Household", DestinationName: "Amazon", SourceId: "1", Tags: []string{"import"}},
+	}
+	if got := expandSplits(s, u); !reflect.DeepEqual(got, want) {
+		t.Errorf("expandSplits() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestModuleHandlerExplain(t *testing.T) {
+	mh, err := NewModuleHandler("", &AliasStore{})
+	if err != nil {
+		t.Fatalf("NewModuleHandler() error = %v", err)
+	}
+	trace, err := mh.Explain(&structs.WhTransactionSplit{JournalId: 1, Description: "mandatereference:mRef,creditorid:,remittanceinformation:Rem; Inf"})
+	if err != nil {
+		t.Fatalf("Explain() error = %v", err)
+	}
+	var ing *TraceStep
+	for i, step := range trace.Steps {
+		if step.Module == "ING description format" {
+			ing = &trace.Steps[i]
+		}
+	}
+	if ing == nil {
+		t.Fatalf("Explain() did not trace rule 'ING description format', got %+v", trace.Steps)
+	}
+	want := TraceStep{
+		Module:   "ING description format",
+		Matched:  true,
+		Captures: map[string]string{"description": "RemInf", "sepa_ci": "", "sepa_db": "mRef"},
+		Changes: []FieldChange{
+			{Field: "description", Old: "mandatereference:mRef,creditorid:,remittanceinformation:RemInf", New: "RemInf"},
+			{Field: "sepa_db", Old: "", New: "mRef"},
+		},
+		Stopped: true,
+	}
+	if !reflect.DeepEqual(*ing, want) {
+		t.Errorf("Explain() step = %+v, want %+v", *ing, want)
+	}
+	if len(trace.Updates) != 1 || trace.Updates[0].Description != "RemInf" {
+		t.Errorf("Explain() updates = %+v", trace.Updates)
+	}
+}
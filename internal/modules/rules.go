@@ -0,0 +1,425 @@
+package modules
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultRules contains the rule files shipped with the application.
+// A file in the user rules directory with the same name, in JSON or YAML, replaces the shipped one.
+//
+//go:embed rules/*.json
+var defaultRules embed.FS
+
+// rulePacks contains shipped rule sets for specific banks.
+// They are only applied to the accounts configured in the rule file referencing them.
+//
+//go:embed packs/*.json
+var rulePacks embed.FS
+
+// rule is the JSON representation of a single entry in a rule file.
+type rule struct {
+	Name        string            `json:"name"`
+	Match       map[string]string `json:"match"`
+	Set         map[string]string `json:"set"`
+	Replace     map[string]string `json:"replace"`
+	Defaults    map[string]string `json:"defaults"`
+	Script      string            `json:"script"`
+	Builtin     string            `json:"builtin"`
+	Options     json.RawMessage   `json:"options"`
+	Pack        string            `json:"pack"`
+	Accounts    []string          `json:"accounts"`
+	ReplaceTags bool              `json:"replace_tags"`
+	Stop        bool              `json:"stop_on_match"`
+}
+
+// builtinProcessor is implemented by modules written in Go.
+// Their name and stop flag are taken from the rule referencing them.
+type builtinProcessor interface {
+	process(s *structs.WhTransactionSplit) (*structs.TransactionSplitUpdate, error)
+}
+
+// builtinModules contains the constructors of modules written in Go, keyed by the identifier rule files use to reference them.
+// Each constructor receives the options from the rule referencing it.
+var builtinModules = map[string]func(env environment, options json.RawMessage) (builtinProcessor, error){
+	"sepa-tags": func(_ environment, _ json.RawMessage) (builtinProcessor, error) {
+		return &moduleSepaTags{}, nil
+	},
+	"keyword-categories": newModuleKeywordCategories,
+	"payee-aliases":      newModulePayeeAliases,
+}
+
+// environment holds the settings and shared state needed to compile rules.
+type environment struct {
+	// rulesDir is used to resolve relative script paths
+	rulesDir string
+	aliases  *AliasStore
+}
+
+// moduleBuiltin runs a module written in Go.
+type moduleBuiltin struct {
+	builtinProcessor
+	ruleName string
+	stop     bool
+}
+
+func (m *moduleBuiltin) name() string {
+	return m.ruleName
+}
+
+func (m *moduleBuiltin) shouldReturnOnSuccess() bool {
+	return m.stop
+}
+
+// runsAfterStop implements interface afterStopModule if the module written in Go does.
+func (m *moduleBuiltin) runsAfterStop() bool {
+	a, ok := m.builtinProcessor.(afterStopModule)
+	return ok && a.runsAfterStop()
+}
+
+// matchableFields contains the fields of an incoming split which rules can match on.
+var matchableFields = map[string]func(s *structs.WhTransactionSplit) string{
+	"description":      func(s *structs.WhTransactionSplit) string { return s.Description },
+	"source_name":      func(s *structs.WhTransactionSplit) string { return s.SourceName },
+	"destination_name": func(s *structs.WhTransactionSplit) string { return s.DestinationName },
+}
+
+// updateFields maps the JSON names of all string and string slice fields in structs.TransactionSplitUpdate to their field index.
+// Fields in splitFields are excluded.
+var updateFields = func() map[string]int {
+	fields := map[string]int{}
+	t := reflect.TypeOf(structs.TransactionSplitUpdate{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type.Kind() != reflect.String && field.Type != reflect.TypeOf([]string{}) {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name != "" && name != "-" && !splitFields[name] {
+			fields[name] = i
+		}
+	}
+	return fields
+}()
+
+// setUpdateField sets the field with the given JSON name.
+// Values for string slice fields are split by commas.
+func setUpdateField(u *structs.TransactionSplitUpdate, field string, value string) {
+	fieldValue := reflect.ValueOf(u).Elem().Field(updateFields[field])
+	if fieldValue.Kind() == reflect.String {
+		fieldValue.SetString(value)
+		return
+	}
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	fieldValue.Set(reflect.ValueOf(values))
+}
+
+// getUpdateField returns the field with the given JSON name.
+// Values of string slice fields are joined by commas.
+func getUpdateField(u *structs.TransactionSplitUpdate, field string) string {
+	fieldValue := reflect.ValueOf(u).Elem().Field(updateFields[field])
+	if fieldValue.Kind() == reflect.String {
+		return fieldValue.String()
+	}
+	return strings.Join(fieldValue.Interface().([]string), ",")
+}
+
+type fieldMatcher struct {
+	field string
+	regex *regexp.Regexp
+}
+
+// moduleRule applies a declarative rule loaded from a rule file.
+type moduleRule struct {
+	ruleName    string
+	stop        bool
+	replaceTags bool
+	matchers    []fieldMatcher
+	set         map[string]string
+	replace     map[string]string
+	defaults    map[string]string
+}
+
+func (m *moduleRule) name() string {
+	return m.ruleName
+}
+
+func (m *moduleRule) shouldReturnOnSuccess() bool {
+	return m.stop
+}
+
+// captures implements interface capturingModule.
+// Returns nil if not all matchers match.
+func (m *moduleRule) captures(s *structs.WhTransactionSplit) map[string]string {
+	captures := map[string]string{}
+	for _, matcher := range m.matchers {
+		match := matcher.regex.FindStringSubmatch(matchableFields[matcher.field](s))
+		if match == nil {
+			return nil
+		}
+		for i, groupName := range matcher.regex.SubexpNames() {
+			if groupName != "" {
+				captures[groupName] = match[i]
+			}
+		}
+	}
+	return captures
+}
+
+func (m *moduleRule) process(s *structs.WhTransactionSplit) (*structs.TransactionSplitUpdate, error) {
+	captures := m.captures(s)
+	if captures == nil {
+		return nil, nil
+	}
+
+	update := &structs.TransactionSplitUpdate{}
+	for groupName, value := range captures {
+		if _, ok := updateFields[groupName]; ok {
+			setUpdateField(update, groupName, value)
+		}
+	}
+	for field, template := range m.set {
+		setUpdateField(update, field, os.Expand(template, func(key string) string {
+			return captures[key]
+		}))
+	}
+	for _, matcher := range m.matchers {
+		if replacement, ok := m.replace[matcher.field]; ok {
+			setUpdateField(update, matcher.field, matcher.regex.ReplaceAllString(matchableFields[matcher.field](s), replacement))
+		}
+	}
+	for field, value := range m.defaults {
+		if getUpdateField(update, field) == "" {
+			setUpdateField(update, field, value)
+		}
+	}
+	update.ReplaceTags = m.replaceTags
+	return update, nil
+}
+
+// compile validates the rule and converts it into a module.
+func (r *rule) compile(env environment) (fixTransactionModule, error) {
+	if r.Name == "" {
+		return nil, errors.New("rule has no name")
+	}
+	if r.Pack != "" {
+		return nil, fmt.Errorf("rule '%s' references pack '%s' from within a pack", r.Name, r.Pack)
+	}
+	if r.Script != "" && r.Builtin != "" {
+		return nil, fmt.Errorf("rule '%s' can not run both a script and a builtin module", r.Name)
+	}
+	if r.Script != "" || r.Builtin != "" {
+		if len(r.Match) != 0 || len(r.Set) != 0 || len(r.Replace) != 0 || len(r.Defaults) != 0 || r.ReplaceTags {
+			return nil, fmt.Errorf("rule '%s' runs a script or builtin module and can not contain match, set, replace, defaults or replace_tags", r.Name)
+		}
+	}
+	if r.Script != "" {
+		return loadScript(r.Name, r.Script, env.rulesDir, r.Stop)
+	}
+	if r.Builtin != "" {
+		newBuiltin, ok := builtinModules[r.Builtin]
+		if !ok {
+			return nil, fmt.Errorf("rule '%s' references unknown builtin module '%s'", r.Name, r.Builtin)
+		}
+		processor, err := newBuiltin(env, r.Options)
+		if err != nil {
+			return nil, fmt.Errorf("rule '%s' has invalid options for builtin module '%s': %w", r.Name, r.Builtin, err)
+		}
+		return &moduleBuiltin{
+			builtinProcessor: processor,
+			ruleName:         r.Name,
+			stop:             r.Stop,
+		}, nil
+	}
+	if len(r.Options) != 0 {
+		return nil, fmt.Errorf("rule '%s' has options but does not reference a builtin module", r.Name)
+	}
+	if len(r.Match) == 0 {
+		return nil, fmt.Errorf("rule '%s' does not match on any field", r.Name)
+	}
+
+	m := &moduleRule{
+		ruleName:    r.Name,
+		stop:        r.Stop,
+		replaceTags: r.ReplaceTags,
+		set:         r.Set,
+		replace:     r.Replace,
+		defaults:    r.Defaults,
+	}
+	groupNames := map[string]bool{}
+	for field, expr := range r.Match {
+		if _, ok := matchableFields[field]; !ok {
+			return nil, fmt.Errorf("rule '%s' matches on unknown field '%s'", r.Name, field)
+		}
+		regex, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("rule '%s' has invalid regex for field '%s': %w", r.Name, field, err)
+		}
+		for _, groupName := range regex.SubexpNames() {
+			groupNames[groupName] = true
+		}
+		m.matchers = append(m.matchers, fieldMatcher{field: field, regex: regex})
+	}
+	// map iteration order is random, sort to apply replacements deterministically
+	sort.Slice(m.matchers, func(i, j int) bool {
+		return m.matchers[i].field < m.matchers[j].field
+	})
+
+	for field, template := range r.Set {
+		if _, ok := updateFields[field]; !ok {
+			return nil, fmt.Errorf("rule '%s' sets unknown field '%s'", r.Name, field)
+		}
+		var err error
+		os.Expand(template, func(key string) string {
+			if !groupNames[key] && err == nil {
+				err = fmt.Errorf("rule '%s' references unknown capture group '%s' in field '%s'", r.Name, key, field)
+			}
+			return ""
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	for field := range r.Replace {
+		if _, ok := r.Match[field]; !ok {
+			return nil, fmt.Errorf("rule '%s' replaces in field '%s' without matching on it", r.Name, field)
+		}
+		if _, ok := updateFields[field]; !ok {
+			return nil, fmt.Errorf("rule '%s' replaces in unknown field '%s'", r.Name, field)
+		}
+	}
+	for field := range r.Defaults {
+		if _, ok := updateFields[field]; !ok {
+			return nil, fmt.Errorf("rule '%s' has default for unknown field '%s'", r.Name, field)
+		}
+	}
+	return m, nil
+}
+
+// loadRules reads the shipped rule files as well as all rule files in env.rulesDir and returns them as modules, ordered by file name.
+// env.rulesDir may be empty, in which case only the shipped rules are loaded.
+func loadRules(env environment) ([]fixTransactionModule, error) {
+	ruleFiles := map[string][]byte{}
+	defaultFiles, err := fs.Glob(defaultRules, "rules/*.json")
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range defaultFiles {
+		content, err := defaultRules.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		ruleFiles[filepath.Base(path)] = content
+	}
+
+	if env.rulesDir != "" {
+		var userFiles []string
+		for _, pattern := range []string{"*.json", "*.yaml", "*.yml"} {
+			matches, err := filepath.Glob(filepath.Join(env.rulesDir, pattern))
+			if err != nil {
+				return nil, err
+			}
+			userFiles = append(userFiles, matches...)
+		}
+		for _, path := range userFiles {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			fileName := filepath.Base(path)
+			// shipped files are replaced regardless of the format
+			delete(ruleFiles, strings.TrimSuffix(fileName, filepath.Ext(fileName))+".json")
+			ruleFiles[fileName] = content
+		}
+	}
+
+	fileNames := make([]string, 0, len(ruleFiles))
+	for fileName := range ruleFiles {
+		fileNames = append(fileNames, fileName)
+	}
+	sort.Strings(fileNames)
+
+	var modules []fixTransactionModule
+	for _, fileName := range fileNames {
+		var rules []rule
+		if err := unmarshalRules(fileName, ruleFiles[fileName], &rules); err != nil {
+			return nil, fmt.Errorf("could not parse rule file %s: %w", fileName, err)
+		}
+		for i := range rules {
+			var ruleModules []fixTransactionModule
+			if rules[i].Pack != "" {
+				if len(rules[i].Accounts) == 0 {
+					return nil, fmt.Errorf("invalid rule file %s: pack '%s' is not restricted to any accounts", fileName, rules[i].Pack)
+				}
+				packModules, err := loadPack(env, rules[i].Pack)
+				if err != nil {
+					return nil, fmt.Errorf("invalid rule file %s: %w", fileName, err)
+				}
+				ruleModules = packModules
+			} else {
+				m, err := rules[i].compile(env)
+				if err != nil {
+					return nil, fmt.Errorf("invalid rule file %s: %w", fileName, err)
+				}
+				ruleModules = []fixTransactionModule{m}
+			}
+			modules = append(modules, withAccountScope(ruleModules, rules[i].Accounts)...)
+		}
+	}
+	return modules, nil
+}
+
+// unmarshalRules parses a rule file in JSON or, by its extension, YAML.
+// YAML is converted to JSON first, so both formats use the JSON names of the fields.
+func unmarshalRules(fileName string, content []byte, rules *[]rule) error {
+	if ext := filepath.Ext(fileName); ext == ".yaml" || ext == ".yml" {
+		var value any
+		if err := yaml.Unmarshal(content, &value); err != nil {
+			return err
+		}
+		converted, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		content = converted
+	}
+	return json.Unmarshal(content, rules)
+}
+
+// loadPack returns the rules of the shipped pack.
+func loadPack(env environment, pack string) ([]fixTransactionModule, error) {
+	content, err := rulePacks.ReadFile("packs/" + pack + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("unknown pack '%s'", pack)
+	}
+	var rules []rule
+	if err := json.Unmarshal(content, &rules); err != nil {
+		return nil, fmt.Errorf("could not parse pack '%s': %w", pack, err)
+	}
+	modules := make([]fixTransactionModule, len(rules))
+	for i := range rules {
+		m, err := rules[i].compile(env)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pack '%s': %w", pack, err)
+		}
+		modules[i] = m
+	}
+	return modules, nil
+}
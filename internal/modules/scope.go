@@ -0,0 +1,66 @@
+package modules
+
+import (
+	"firefly-iii-fix-ing/internal/structs"
+	"slices"
+	"strings"
+)
+
+// moduleAccountScope restricts a module to splits with one of the given accounts as source or destination.
+// Accounts can be given by their name or ID.
+type moduleAccountScope struct {
+	fixTransactionModule
+	accounts []string
+}
+
+func (m *moduleAccountScope) inScope(s *structs.WhTransactionSplit) bool {
+	return slices.ContainsFunc(m.accounts, func(account string) bool {
+		return account == s.SourceName || account == s.DestinationName ||
+			account == string(s.SourceId) || account == string(s.DestinationId)
+	})
+}
+
+func (m *moduleAccountScope) process(s *structs.WhTransactionSplit) (*structs.TransactionSplitUpdate, error) {
+	if !m.inScope(s) {
+		return nil, nil
+	}
+	return m.fixTransactionModule.process(s)
+}
+
+// runsAfterStop implements interface afterStopModule if the restricted module does.
+func (m *moduleAccountScope) runsAfterStop() bool {
+	return runsAfterStop(m.fixTransactionModule)
+}
+
+// captures implements interface capturingModule if the restricted module does.
+func (m *moduleAccountScope) captures(s *structs.WhTransactionSplit) map[string]string {
+	inner, ok := m.fixTransactionModule.(capturingModule)
+	if !ok || !m.inScope(s) {
+		return nil
+	}
+	return inner.captures(s)
+}
+
+// withAccountScope restricts all modules to the given accounts.
+// The modules are returned unchanged if accounts is empty.
+func withAccountScope(modules []fixTransactionModule, accounts []string) []fixTransactionModule {
+	if len(accounts) == 0 {
+		return modules
+	}
+	scoped := make([]fixTransactionModule, len(modules))
+	for i, m := range modules {
+		scoped[i] = &moduleAccountScope{
+			fixTransactionModule: m,
+			accounts:             accounts,
+		}
+	}
+	return scoped
+}
+
+// describeScope returns a human-readable description of the accounts a module is restricted to.
+func describeScope(m fixTransactionModule) string {
+	if scoped, ok := m.(*moduleAccountScope); ok {
+		return "accounts: " + strings.Join(scoped.accounts, ", ")
+	}
+	return "all accounts"
+}
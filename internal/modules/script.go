@@ -0,0 +1,239 @@
+package modules
+
+import (
+	"errors"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+const (
+	// scriptMaxSteps limits the number of Starlark computation steps per script call.
+	scriptMaxSteps = 1_000_000
+	// scriptTimeout limits the wall time per script call.
+	scriptTimeout = 1 * time.Second
+	// scriptEntrypoint is the name of the function each script needs to define.
+	scriptEntrypoint = "process"
+	// scriptReplaceTags is the key scripts return to replace existing tags instead of adding to them.
+	scriptReplaceTags = "replace_tags"
+	// scriptSplits is the key scripts return to split the transaction into multiple splits.
+	scriptSplits = "splits"
+	// scriptAmount is the key for the amount of each split.
+	scriptAmount = "amount"
+)
+
+// moduleScript runs a user-supplied Starlark script.
+//
+// The script needs to define a function process(t) which receives the split as a struct with its fields named like in the Firefly API.
+// It returns None if not applicable or a dict of fields to update, using the same field names as rule files.
+// Tags are passed and can be returned as list.
+// To split the transaction, the dict contains a list of dicts with the amount and fields of each split under "splits".
+//
+// Scripts have no access to the file system or network.
+type moduleScript struct {
+	scriptName string
+	stop       bool
+	fn         starlark.Callable
+}
+
+func (m *moduleScript) name() string {
+	return m.scriptName
+}
+
+func (m *moduleScript) shouldReturnOnSuccess() bool {
+	return m.stop
+}
+
+func newScriptThread(name string) (*starlark.Thread, func()) {
+	thread := &starlark.Thread{
+		Name: name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Printf(">>>> [%s]: %s", name, msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(scriptMaxSteps)
+	timer := time.AfterFunc(scriptTimeout, func() {
+		thread.Cancel(fmt.Sprintf("timeout after %s", scriptTimeout))
+	})
+	return thread, func() { timer.Stop() }
+}
+
+func (m *moduleScript) process(s *structs.WhTransactionSplit) (*structs.TransactionSplitUpdate, error) {
+	thread, stop := newScriptThread(m.scriptName)
+	defer stop()
+
+	result, err := starlark.Call(thread, m.fn, starlark.Tuple{splitToStarlark(s)}, nil)
+	if err != nil {
+		var evalErr *starlark.EvalError
+		if errors.As(err, &evalErr) {
+			return nil, errors.New(evalErr.Backtrace())
+		}
+		return nil, err
+	}
+	if result == starlark.None {
+		return nil, nil
+	}
+	dict, ok := result.(*starlark.Dict)
+	if !ok {
+		return nil, fmt.Errorf("%s() returned %s, expected dict or None", scriptEntrypoint, result.Type())
+	}
+	return dictToUpdate(dict, false)
+}
+
+// dictToUpdate converts a dict returned by a script into an update.
+// Split dicts need to contain the amount of the split.
+func dictToUpdate(dict *starlark.Dict, isSplit bool) (*structs.TransactionSplitUpdate, error) {
+	update := &structs.TransactionSplitUpdate{}
+	for _, item := range dict.Items() {
+		field, ok := starlark.AsString(item[0])
+		if !ok {
+			return nil, fmt.Errorf("%s() returned non-string key %s", scriptEntrypoint, item[0])
+		}
+		switch {
+		case field == scriptReplaceTags:
+			update.ReplaceTags = bool(item[1].Truth())
+			continue
+		case field == scriptSplits && !isSplit:
+			splits, err := listToSplits(item[1])
+			if err != nil {
+				return nil, err
+			}
+			update.Splits = splits
+			continue
+		case field == scriptAmount && isSplit:
+			amount, err := starlarkToAmount(item[1])
+			if err != nil {
+				return nil, err
+			}
+			update.Amount = amount
+			continue
+		}
+		if _, ok := updateFields[field]; !ok {
+			return nil, fmt.Errorf("%s() returned unknown field '%s'", scriptEntrypoint, field)
+		}
+		if list, ok := item[1].(*starlark.List); ok {
+			// lists are allowed for string slice fields like tags
+			values := make([]string, list.Len())
+			for i := range values {
+				if values[i], ok = starlark.AsString(list.Index(i)); !ok {
+					return nil, fmt.Errorf("%s() returned non-string list element for field '%s'", scriptEntrypoint, field)
+				}
+			}
+			item[1] = starlark.String(strings.Join(values, ","))
+		}
+		value, ok := starlark.AsString(item[1])
+		if !ok {
+			return nil, fmt.Errorf("%s() returned non-string value for field '%s'", scriptEntrypoint, field)
+		}
+		setUpdateField(update, field, value)
+	}
+	if isSplit && update.Amount == "" {
+		return nil, fmt.Errorf("%s() returned split without '%s'", scriptEntrypoint, scriptAmount)
+	}
+	return update, nil
+}
+
+// listToSplits converts the list of split dicts returned by a script.
+func listToSplits(v starlark.Value) ([]structs.TransactionSplitUpdate, error) {
+	list, ok := v.(*starlark.List)
+	if !ok {
+		return nil, fmt.Errorf("%s() returned %s for '%s', expected list", scriptEntrypoint, v.Type(), scriptSplits)
+	}
+	splits := make([]structs.TransactionSplitUpdate, list.Len())
+	for i := range splits {
+		dict, ok := list.Index(i).(*starlark.Dict)
+		if !ok {
+			return nil, fmt.Errorf("%s() returned %s as split, expected dict", scriptEntrypoint, list.Index(i).Type())
+		}
+		split, err := dictToUpdate(dict, true)
+		if err != nil {
+			return nil, err
+		}
+		splits[i] = *split
+	}
+	return splits, nil
+}
+
+// starlarkToAmount converts an amount returned by a script. Floats are rounded to cents.
+func starlarkToAmount(v starlark.Value) (string, error) {
+	switch amount := v.(type) {
+	case starlark.String:
+		return string(amount), nil
+	case starlark.Int:
+		return amount.String(), nil
+	case starlark.Float:
+		return strconv.FormatFloat(float64(amount), 'f', 2, 64), nil
+	default:
+		return "", fmt.Errorf("%s() returned %s as amount", scriptEntrypoint, v.Type())
+	}
+}
+
+// splitToStarlark converts the split into a read-only Starlark struct.
+// The amount is converted to a float if possible.
+func splitToStarlark(s *structs.WhTransactionSplit) *starlarkstruct.Struct {
+	fields := starlark.StringDict{}
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		switch field := v.Field(i); field.Kind() {
+		case reflect.String:
+			fields[name] = starlark.String(field.String())
+		case reflect.Int:
+			fields[name] = starlark.MakeInt(int(field.Int()))
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.String {
+				continue
+			}
+			values := make([]starlark.Value, field.Len())
+			for k := range values {
+				values[k] = starlark.String(field.Index(k).String())
+			}
+			fields[name] = starlark.NewList(values)
+		}
+	}
+	if amount, err := strconv.ParseFloat(s.Amount, 64); err == nil {
+		fields["amount"] = starlark.Float(amount)
+	}
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, fields)
+}
+
+// loadScript executes the script file once to obtain its process function.
+// Relative paths are resolved against rulesDir.
+func loadScript(name string, path string, rulesDir string, stop bool) (*moduleScript, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rulesDir, path)
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	thread, stopTimer := newScriptThread(name)
+	defer stopTimer()
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, filepath.Base(path), src, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not load script %s: %w", path, err)
+	}
+	fn, ok := globals[scriptEntrypoint].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("script %s does not define function %s(t)", path, scriptEntrypoint)
+	}
+	return &moduleScript{
+		scriptName: name,
+		stop:       stop,
+		fn:         fn,
+	}, nil
+}
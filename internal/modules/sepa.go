@@ -0,0 +1,69 @@
+package modules
+
+import (
+	"firefly-iii-fix-ing/internal/structs"
+	"regexp"
+	"strings"
+)
+
+// moduleSepaTags parses structured SEPA remittance information like "EREF+... MREF+... CRED+... SVWZ+..." used by many German banks.
+type moduleSepaTags struct {
+}
+
+var regexSepaTag = regexp.MustCompile(`(EREF|KREF|MREF|CRED|DEBT|SVWZ|ABWA|ABWE|IBAN|BIC|PURP|COAM|OAMT|SQTP)\+`)
+
+// sepaNotProvided is used by banks for empty references.
+const sepaNotProvided = "NOTPROVIDED"
+
+// parseSepaTags splits the description into its tags.
+// Returns nil if the description does not start with a known tag.
+func parseSepaTags(description string) map[string]string {
+	description = strings.TrimSpace(description)
+	locs := regexSepaTag.FindAllStringSubmatchIndex(description, -1)
+	if len(locs) == 0 || locs[0][0] != 0 {
+		return nil
+	}
+	tags := map[string]string{}
+	for i, loc := range locs {
+		end := len(description)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		value := strings.TrimSpace(description[loc[1]:end])
+		if value == sepaNotProvided {
+			value = ""
+		}
+		tags[description[loc[2]:loc[3]]] = value
+	}
+	return tags
+}
+
+func (m *moduleSepaTags) process(s *structs.WhTransactionSplit) (*structs.TransactionSplitUpdate, error) {
+	tags := parseSepaTags(s.Description)
+	if tags == nil {
+		return nil, nil
+	}
+	description := tags["SVWZ"]
+	if description == "" {
+		description = "n/a"
+	}
+
+	var notes []string
+	if tags["ABWA"] != "" {
+		notes = append(notes, "Ultimate payer: "+tags["ABWA"])
+	}
+	if tags["ABWE"] != "" {
+		notes = append(notes, "Ultimate payee: "+tags["ABWE"])
+	}
+	if tags["KREF"] != "" {
+		notes = append(notes, "Customer reference: "+tags["KREF"])
+	}
+	return &structs.TransactionSplitUpdate{
+		Description:      description,
+		MandateReference: tags["MREF"],
+		SepaCi:           tags["CRED"],
+		SepaCtId:         tags["EREF"],
+		SepaEp:           tags["PURP"],
+		Notes:            strings.Join(notes, "\n"),
+	}, nil
+}
@@ -0,0 +1,98 @@
+package modules
+
+import (
+	"errors"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// splitFields contains the JSON names of fields which describe the split itself.
+// They are only set when splitting a transaction and cannot be changed by rules.
+var splitFields = map[string]bool{
+	"type":           true,
+	"date":           true,
+	"amount":         true,
+	"source_id":      true,
+	"destination_id": true,
+}
+
+// parseAmount parses a decimal amount as sent by Firefly, ignoring its sign.
+func parseAmount(amount string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(strings.TrimSpace(amount))
+	if !ok {
+		return nil, fmt.Errorf("invalid amount '%s'", amount)
+	}
+	return r.Abs(r), nil
+}
+
+// validateSplits checks that there are at least two splits with positive amounts which sum up to amount.
+func validateSplits(splits []structs.TransactionSplitUpdate, amount string) error {
+	if len(splits) < 2 {
+		return errors.New("need at least two splits")
+	}
+	total, err := parseAmount(amount)
+	if err != nil {
+		return err
+	}
+	sum := new(big.Rat)
+	for i, split := range splits {
+		if split.Amount == "" {
+			return fmt.Errorf("split #%d has no amount", i+1)
+		}
+		splitAmount, err := parseAmount(split.Amount)
+		if err != nil {
+			return fmt.Errorf("split #%d: %w", i+1, err)
+		}
+		if splitAmount.Sign() == 0 {
+			return fmt.Errorf("split #%d has zero amount", i+1)
+		}
+		sum.Add(sum, splitAmount)
+	}
+	if sum.Cmp(total) != 0 {
+		return fmt.Errorf("amounts of splits sum up to %s instead of %s", sum.FloatString(2), total.FloatString(2))
+	}
+	return nil
+}
+
+// expandSplits returns the updates for the split s.
+//
+// Without splits, this is u itself. Otherwise, there is one update per split, with the first one
+// updating the existing journal and the others creating new journals with the type, date and accounts of s.
+func expandSplits(s *structs.WhTransactionSplit, u *structs.TransactionSplitUpdate) []structs.TransactionSplitUpdate {
+	if len(u.Splits) == 0 {
+		return []structs.TransactionSplitUpdate{*u}
+	}
+	updates := make([]structs.TransactionSplitUpdate, len(u.Splits))
+	for i := range u.Splits {
+		update := *u
+		update.Splits = nil
+		update.Tags = slices.Clone(u.Tags)
+		mergeTransactionUpdates(&u.Splits[i], &update, s, fmt.Sprintf("split #%d", i+1))
+		if i > 0 {
+			update.JournalId = 0
+			update.Type = s.Type
+			update.Date = s.Date
+			// new journals keep the fields of the original split which are not updated
+			if update.CategoryName == "" {
+				update.CategoryName = s.CategoryName
+			}
+			if update.Notes == "" {
+				update.Notes = s.Notes
+			}
+			if update.Tags == nil {
+				update.Tags = slices.Clone(s.Tags)
+			}
+			if update.SourceName == "" {
+				update.SourceId = s.SourceId
+			}
+			if update.DestinationName == "" {
+				update.DestinationId = s.DestinationId
+			}
+		}
+		updates[i] = update
+	}
+	return updates
+}
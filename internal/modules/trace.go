@@ -0,0 +1,108 @@
+package modules
+
+import (
+	"encoding/json"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// capturingModule is implemented by modules which match regular expressions, to report their captured groups.
+type capturingModule interface {
+	captures(s *structs.WhTransactionSplit) map[string]string
+}
+
+// Trace describes what the modules did with a split during a dry run.
+type Trace struct {
+	Split   structs.WhTransactionSplit       `json:"split"`
+	Steps   []TraceStep                      `json:"steps"`
+	Updates []structs.TransactionSplitUpdate `json:"updates"`
+}
+
+// TraceStep describes what a single module did with the split.
+type TraceStep struct {
+	Module   string            `json:"module"`
+	Matched  bool              `json:"matched"`
+	Error    string            `json:"error,omitempty"`
+	Captures map[string]string `json:"captures,omitempty"`
+	Changes  []FieldChange     `json:"changes,omitempty"`
+	// Stopped is set if the module stopped processing of further modules
+	Stopped bool `json:"stopped,omitempty"`
+}
+
+// FieldChange is the change of a single update field, using the field names of rule files.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+func (t *Trace) add(step TraceStep) {
+	if t != nil {
+		t.Steps = append(t.Steps, step)
+	}
+}
+
+// Explain runs the split through all modules like Process, recording what each module did.
+func (mh *ModuleHandler) Explain(s *structs.WhTransactionSplit) (*Trace, error) {
+	trace := &Trace{Split: *s}
+	updates, err := mh.process(s, trace)
+	trace.Updates = updates
+	return trace, err
+}
+
+// diffUpdates returns the changes between two updates, sorted by field name.
+func diffUpdates(before *structs.TransactionSplitUpdate, after *structs.TransactionSplitUpdate) []FieldChange {
+	var changes []FieldChange
+	for field := range updateFields {
+		if oldValue, newValue := getUpdateField(before, field), getUpdateField(after, field); oldValue != newValue {
+			changes = append(changes, FieldChange{Field: field, Old: oldValue, New: newValue})
+		}
+	}
+	if len(before.Splits) != len(after.Splits) {
+		changes = append(changes, FieldChange{Field: "splits", Old: fmt.Sprint(len(before.Splits)), New: fmt.Sprint(len(after.Splits))})
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// String formats the trace for humans.
+func (t *Trace) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Split #%d: '%s'\n", t.Split.JournalId, t.Split.Description)
+	for i, step := range t.Steps {
+		status := "not applicable"
+		if step.Error != "" {
+			status = "ERROR: " + step.Error
+		} else if step.Matched {
+			status = "matched"
+		}
+		fmt.Fprintf(&b, "%3d. [%s]: %s\n", i+1, step.Module, status)
+
+		groups := make([]string, 0, len(step.Captures))
+		for group := range step.Captures {
+			groups = append(groups, group)
+		}
+		sort.Strings(groups)
+		for _, group := range groups {
+			fmt.Fprintf(&b, "       captured %s='%s'\n", group, step.Captures[group])
+		}
+		for _, change := range step.Changes {
+			fmt.Fprintf(&b, "       %s: '%s' -> '%s'\n", change.Field, change.Old, change.New)
+		}
+		if step.Stopped {
+			b.WriteString("       stopped processing\n")
+		}
+	}
+	if len(t.Updates) == 0 {
+		b.WriteString("Result: no update\n")
+	}
+	for i, update := range t.Updates {
+		content, _ := json.Marshal(update)
+		fmt.Fprintf(&b, "Result #%d: %s\n", i+1, content)
+	}
+	return b.String()
+}
@@ -0,0 +1,248 @@
+// Package queue persists webhook deliveries until they were processed, retrying failed ones and keeping those which failed for good.
+package queue
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketPending = []byte("pending")
+	bucketDead    = []byte("dead")
+)
+
+// openTimeout is how long an operation waits while the queue command or the service holds the database.
+const openTimeout = 5 * time.Second
+
+// Job is a webhook delivery to process.
+type Job struct {
+	// Key identifies the job, jobs with the same key replace each other
+	Key           string          `json:"key"`
+	Trigger       string          `json:"trigger"`
+	TransactionId int             `json:"transaction_id"`
+	Payload       json.RawMessage `json:"payload"`
+	// Seq changes whenever the job is pushed, so a job replaced while it was processed is not removed afterwards
+	Seq         uint64    `json:"seq"`
+	Received    time.Time `json:"received"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+	// Steps contains the steps completed in earlier attempts, so retries do not repeat their side effects
+	Steps []string `json:"steps,omitempty"`
+}
+
+// Queue holds pending jobs and dead jobs, stored in a bbolt database.
+// Every operation opens and closes the database, as bbolt locks it exclusively and the queue command
+// has to list and replay jobs while the service is processing them.
+type Queue struct {
+	path string
+}
+
+// New creates a queue stored at path.
+func New(path string) *Queue {
+	return &Queue{path: path}
+}
+
+func (q *Queue) update(fn func(pending *bolt.Bucket, dead *bolt.Bucket) error) error {
+	db, err := bolt.Open(q.path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(tx *bolt.Tx) error {
+		pending, err := tx.CreateBucketIfNotExists(bucketPending)
+		if err != nil {
+			return err
+		}
+		dead, err := tx.CreateBucketIfNotExists(bucketDead)
+		if err != nil {
+			return err
+		}
+		return fn(pending, dead)
+	})
+}
+
+// Push adds the job to the pending jobs, replacing a pending job with the same key.
+// The job is due immediately.
+func (q *Queue) Push(job Job) error {
+	return q.update(func(pending *bolt.Bucket, _ *bolt.Bucket) error {
+		seq, err := pending.NextSequence()
+		if err != nil {
+			return err
+		}
+		job.Seq = seq
+		job.Attempts = 0
+		job.LastError = ""
+		job.NextAttempt = time.Time{}
+		if job.Received.IsZero() {
+			job.Received = time.Now()
+		}
+		return put(pending, &job)
+	})
+}
+
+// Due returns the pending jobs which are due at now and not excluded, longest due first.
+func (q *Queue) Due(now time.Time, exclude map[string]bool) ([]Job, error) {
+	var jobs []Job
+	err := q.update(func(pending *bolt.Bucket, _ *bolt.Bucket) error {
+		return pending.ForEach(func(k, v []byte) error {
+			if exclude[string(k)] {
+				return nil
+			}
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			if !job.NextAttempt.After(now) {
+				jobs = append(jobs, job)
+			}
+			return nil
+		})
+	})
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].NextAttempt.Before(jobs[j].NextAttempt) })
+	return jobs, err
+}
+
+// Done removes the processed job, unless it was replaced in the meantime.
+func (q *Queue) Done(job Job) error {
+	return q.update(func(pending *bolt.Bucket, _ *bolt.Bucket) error {
+		if !unchanged(pending, job) {
+			return nil
+		}
+		return pending.Delete([]byte(job.Key))
+	})
+}
+
+// Retry records the failed attempt and schedules the job again at next, unless it was replaced in the meantime.
+func (q *Queue) Retry(job Job, cause error, next time.Time) error {
+	return q.update(func(pending *bolt.Bucket, _ *bolt.Bucket) error {
+		if !unchanged(pending, job) {
+			return nil
+		}
+		job.Attempts++
+		job.LastError = cause.Error()
+		job.NextAttempt = next
+		return put(pending, &job)
+	})
+}
+
+// Bury records the failed attempt and moves the job to the dead jobs, unless it was replaced in the meantime.
+func (q *Queue) Bury(job Job, cause error) error {
+	return q.update(func(pending *bolt.Bucket, dead *bolt.Bucket) error {
+		if !unchanged(pending, job) {
+			return nil
+		}
+		job.Attempts++
+		job.LastError = cause.Error()
+		if err := pending.Delete([]byte(job.Key)); err != nil {
+			return err
+		}
+		return put(dead, &job)
+	})
+}
+
+// Remove removes the pending job with the given key, if any.
+func (q *Queue) Remove(key string) error {
+	return q.update(func(pending *bolt.Bucket, _ *bolt.Bucket) error {
+		return pending.Delete([]byte(key))
+	})
+}
+
+// Pending returns all pending jobs, oldest first.
+func (q *Queue) Pending() ([]Job, error) {
+	return q.list(false)
+}
+
+// Dead returns all dead jobs, oldest first.
+func (q *Queue) Dead() ([]Job, error) {
+	return q.list(true)
+}
+
+func (q *Queue) list(fromDead bool) ([]Job, error) {
+	var jobs []Job
+	err := q.update(func(pending *bolt.Bucket, dead *bolt.Bucket) error {
+		b := pending
+		if fromDead {
+			b = dead
+		}
+		return b.ForEach(func(_, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			return nil
+		})
+	})
+	sort.SliceStable(jobs, func(i, j int) bool { return jobs[i].Received.Before(jobs[j].Received) })
+	return jobs, err
+}
+
+// Replay moves the dead jobs with the given keys, or all if none are given, back to the pending jobs.
+// A pending job with the same key is newer and kept instead.
+// Returns the number of replayed jobs.
+func (q *Queue) Replay(keys ...string) (int, error) {
+	replayed := 0
+	err := q.update(func(pending *bolt.Bucket, dead *bolt.Bucket) error {
+		if len(keys) == 0 {
+			if err := dead.ForEach(func(k, _ []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		for _, key := range keys {
+			v := dead.Get([]byte(key))
+			if v == nil {
+				continue
+			}
+			if pending.Get([]byte(key)) == nil {
+				var job Job
+				if err := json.Unmarshal(v, &job); err != nil {
+					return err
+				}
+				seq, err := pending.NextSequence()
+				if err != nil {
+					return err
+				}
+				job.Seq = seq
+				job.Attempts = 0
+				job.NextAttempt = time.Time{}
+				if err = put(pending, &job); err != nil {
+					return err
+				}
+			}
+			if err := dead.Delete([]byte(key)); err != nil {
+				return err
+			}
+			replayed++
+		}
+		return nil
+	})
+	return replayed, err
+}
+
+// unchanged returns whether the pending job with the key of job has the same sequence number.
+func unchanged(pending *bolt.Bucket, job Job) bool {
+	v := pending.Get([]byte(job.Key))
+	if v == nil {
+		return false
+	}
+	var stored Job
+	if err := json.Unmarshal(v, &stored); err != nil {
+		return false
+	}
+	return stored.Seq == job.Seq
+}
+
+func put(b *bolt.Bucket, job *Job) error {
+	v, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(job.Key), v)
+}
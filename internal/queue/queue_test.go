@@ -0,0 +1,141 @@
+package queue
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func keys(jobs []Job) []string {
+	var result []string
+	for _, job := range jobs {
+		result = append(result, job.Key)
+	}
+	return result
+}
+
+func equalKeys(got []string, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestQueuePushReplaces(t *testing.T) {
+	q := New(filepath.Join(t.TempDir(), "queue.db"))
+	if err := q.Push(Job{Key: "a", Payload: []byte(`1`)}); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	due, err := q.Due(time.Now(), nil)
+	if err != nil {
+		t.Fatalf("Due() error = %v", err)
+	}
+	processing := due[0]
+
+	// a newer delivery arrives while the first one is processed
+	if err = q.Push(Job{Key: "a", Payload: []byte(`2`)}); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if err = q.Done(processing); err != nil {
+		t.Fatalf("Done() error = %v", err)
+	}
+	pending, err := q.Pending()
+	if err != nil {
+		t.Fatalf("Pending() error = %v", err)
+	}
+	if len(pending) != 1 || string(pending[0].Payload) != `2` {
+		t.Fatalf("Pending() = %v, want only the newer delivery", pending)
+	}
+
+	if err = q.Done(pending[0]); err != nil {
+		t.Fatalf("Done() error = %v", err)
+	}
+	if pending, _ = q.Pending(); len(pending) != 0 {
+		t.Errorf("Pending() = %v, want none", pending)
+	}
+}
+
+func TestQueueDue(t *testing.T) {
+	now := time.Now()
+	q := New(filepath.Join(t.TempDir(), "queue.db"))
+	for _, key := range []string{"a", "b", "c"} {
+		if err := q.Push(Job{Key: key}); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	}
+	due, _ := q.Due(now, nil)
+	if err := q.Retry(due[0], errors.New("failed"), now.Add(time.Minute)); err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		exclude map[string]bool
+		want    []string
+	}{
+		{"retry not due", now, nil, []string{"b", "c"}},
+		{"excluded", now, map[string]bool{"b": true}, []string{"c"}},
+		{"retry due", now.Add(time.Minute), nil, []string{"b", "c", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := q.Due(tt.now, tt.exclude)
+			if err != nil {
+				t.Fatalf("Due() error = %v", err)
+			}
+			if keys := keys(got); !equalKeys(keys, tt.want) {
+				t.Errorf("Due() = %v, want %v", keys, tt.want)
+			}
+		})
+	}
+
+	retried, _ := q.Due(now.Add(time.Minute), map[string]bool{"b": true, "c": true})
+	if retried[0].Attempts != 1 || retried[0].LastError != "failed" {
+		t.Errorf("retried job = %+v, want 1 attempt with error", retried[0])
+	}
+}
+
+func TestQueueBuryAndReplay(t *testing.T) {
+	q := New(filepath.Join(t.TempDir(), "queue.db"))
+	for _, key := range []string{"a", "b"} {
+		if err := q.Push(Job{Key: key}); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	}
+	due, _ := q.Due(time.Now(), nil)
+	for _, job := range due {
+		if err := q.Bury(job, errors.New("failed")); err != nil {
+			t.Fatalf("Bury() error = %v", err)
+		}
+	}
+	dead, err := q.Dead()
+	if err != nil {
+		t.Fatalf("Dead() error = %v", err)
+	}
+	if keys := keys(dead); !equalKeys(keys, []string{"a", "b"}) {
+		t.Fatalf("Dead() = %v, want [a b]", keys)
+	}
+
+	replayed, err := q.Replay("b", "unknown")
+	if err != nil || replayed != 1 {
+		t.Fatalf("Replay() = %d, %v, want 1", replayed, err)
+	}
+	due, _ = q.Due(time.Now(), nil)
+	if keys := keys(due); !equalKeys(keys, []string{"b"}) || due[0].Attempts != 0 {
+		t.Errorf("Due() after replay = %v, want [b] without attempts", due)
+	}
+
+	if replayed, err = q.Replay(); err != nil || replayed != 1 {
+		t.Errorf("Replay() all = %d, %v, want 1", replayed, err)
+	}
+	if dead, _ = q.Dead(); len(dead) != 0 {
+		t.Errorf("Dead() after replay = %v, want none", dead)
+	}
+}
@@ -1,6 +1,8 @@
 // Package structs contains the JSON structs for the Firefly API
 package structs
 
+import "encoding/json"
+
 type TransactionUpdate struct {
 	ApplyRules         bool                     `json:"apply_rules"`
 	FireWebhooks       bool                     `json:"fire_webhooks"`
@@ -9,11 +11,36 @@ type TransactionUpdate struct {
 }
 
 type TransactionSplitUpdate struct {
-	JournalId        int    `json:"transaction_journal_id"`
-	Description      string `json:"description,omitempty"`
-	MandateReference string `json:"sepa_db,omitempty"`
-	CreditorId       string `json:"destination_iban,omitempty"`
-	CategoryName     string `json:"category_name,omitempty"`
+	JournalId         int      `json:"transaction_journal_id,omitempty"`
+	Type              string   `json:"type,omitempty"`
+	Date              string   `json:"date,omitempty"`
+	Amount            string   `json:"amount,omitempty"`
+	Description       string   `json:"description,omitempty"`
+	MandateReference  string   `json:"sepa_db,omitempty"`
+	CreditorId        string   `json:"destination_iban,omitempty"`
+	CategoryName      string   `json:"category_name,omitempty"`
+	SourceName        string   `json:"source_name,omitempty"`
+	DestinationName   string   `json:"destination_name,omitempty"`
+	SourceId          Id       `json:"source_id,omitempty"`
+	DestinationId     Id       `json:"destination_id,omitempty"`
+	SepaCc            string   `json:"sepa_cc,omitempty"`
+	SepaCtOp          string   `json:"sepa_ct_op,omitempty"`
+	SepaCtId          string   `json:"sepa_ct_id,omitempty"`
+	SepaCountry       string   `json:"sepa_country,omitempty"`
+	SepaEp            string   `json:"sepa_ep,omitempty"`
+	SepaCi            string   `json:"sepa_ci,omitempty"`
+	SepaBatchId       string   `json:"sepa_batch_id,omitempty"`
+	Notes             string   `json:"notes,omitempty"`
+	Tags              []string `json:"tags,omitempty"`
+	BudgetName        string   `json:"budget_name,omitempty"`
+	BillName          string   `json:"bill_name,omitempty"`
+	InternalReference string   `json:"internal_reference,omitempty"`
+	ExternalUrl       string   `json:"external_url,omitempty"`
+	// ReplaceTags makes Tags replace the existing tags instead of being added to them
+	ReplaceTags bool `json:"-"`
+	// Splits replaces the split by multiple splits whose amounts sum up to the original amount.
+	// Fields not set in a split are taken from the update.
+	Splits []TransactionSplitUpdate `json:"-"`
 }
 
 type TransactionRead struct {
@@ -21,18 +48,91 @@ type TransactionRead struct {
 	Attributes struct {
 		GroupTitle   string `json:"group_title"`
 		Transactions []struct {
-			JournalId       string `json:"transaction_journal_id"`
-			Amount          string `json:"amount"`
-			CurrencySymbol  string `json:"currency_symbol"`
-			Description     string `json:"description"`
-			DestinationName string `json:"destination_name"`
-			SourceName      string `json:"source_name"`
-			CategoryName    string `json:"category_name"`
-			Date            string `json:"date"`
+			JournalId       string   `json:"transaction_journal_id"`
+			Type            string   `json:"type"`
+			Amount          string   `json:"amount"`
+			CurrencySymbol  string   `json:"currency_symbol"`
+			Description     string   `json:"description"`
+			DestinationId   string   `json:"destination_id"`
+			DestinationName string   `json:"destination_name"`
+			DestinationIban string   `json:"destination_iban"`
+			SourceId        string   `json:"source_id"`
+			SourceName      string   `json:"source_name"`
+			SourceIban      string   `json:"source_iban"`
+			CategoryName    string   `json:"category_name"`
+			Date            string   `json:"date"`
+			SepaCtId        string   `json:"sepa_ct_id"`
+			Tags            []string `json:"tags"`
+			BillId          string   `json:"bill_id"`
+			BillName        string   `json:"bill_name"`
+			Notes           string   `json:"notes"`
 		} `json:"transactions"`
 	} `json:"attributes"`
 }
 
+type AccountRead struct {
+	Id         string `json:"id"`
+	Attributes struct {
+		Name string `json:"name"`
+		Type string `json:"type"`
+		Iban string `json:"iban"`
+	} `json:"attributes"`
+}
+
+type BillRead struct {
+	Id         string         `json:"id"`
+	Attributes BillAttributes `json:"attributes"`
+}
+
+type BillAttributes struct {
+	Name       string `json:"name"`
+	AmountMin  string `json:"amount_min"`
+	AmountMax  string `json:"amount_max"`
+	Date       string `json:"date"`
+	RepeatFreq string `json:"repeat_freq"`
+	Active     bool   `json:"active"`
+}
+
+type TagRead struct {
+	Id         string `json:"id"`
+	Attributes struct {
+		Tag         string `json:"tag"`
+		Description string `json:"description"`
+	} `json:"attributes"`
+}
+
+type BudgetRead struct {
+	Id         string `json:"id"`
+	Attributes struct {
+		Name   string `json:"name"`
+		Active bool   `json:"active"`
+	} `json:"attributes"`
+}
+
+type About struct {
+	Version    string `json:"version"`
+	ApiVersion string `json:"api_version"`
+	PhpVersion string `json:"php_version"`
+	Os         string `json:"os"`
+	Driver     string `json:"driver"`
+}
+
+type UserRead struct {
+	Id         string `json:"id"`
+	Attributes struct {
+		Email       string `json:"email"`
+		Blocked     bool   `json:"blocked"`
+		BlockedCode string `json:"blocked_code"`
+		Role        string `json:"role"`
+	} `json:"attributes"`
+}
+
+type Pagination struct {
+	Total       int `json:"total"`
+	CurrentPage int `json:"current_page"`
+	TotalPages  int `json:"total_pages"`
+}
+
 type WebhookRead struct {
 	Id         string            `json:"id"`
 	Attributes WebhookAttributes `json:"attributes"`
@@ -59,13 +159,36 @@ type WhTransactionRead struct {
 }
 
 type WhTransactionSplit struct {
-	JournalId       int    `json:"transaction_journal_id"`
-	Date            string `json:"date"`
-	Amount          string `json:"amount"`
-	CurrencySymbol  string `json:"currency_symbol"`
-	Description     string `json:"description"`
-	SourceName      string `json:"source_name"`
-	DestinationName string `json:"destination_name"`
+	JournalId       int      `json:"transaction_journal_id"`
+	Type            string   `json:"type"`
+	Date            string   `json:"date"`
+	Amount          string   `json:"amount"`
+	CurrencySymbol  string   `json:"currency_symbol"`
+	Description     string   `json:"description"`
+	SourceId        Id       `json:"source_id"`
+	SourceName      string   `json:"source_name"`
+	DestinationId   Id       `json:"destination_id"`
+	DestinationName string   `json:"destination_name"`
+	CategoryName    string   `json:"category_name"`
+	Notes           string   `json:"notes"`
+	Tags            []string `json:"tags"`
+}
+
+// Id is an identifier which Firefly sends either as a JSON number or a JSON string.
+type Id string
+
+func (id *Id) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*id = Id(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*id = Id(n.String())
+	return nil
 }
 
 type WhUrlResult struct {
@@ -0,0 +1,260 @@
+// Package transport provides a HTTP transport retrying failed requests and stopping requests to unavailable hosts
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned for requests to a host whose circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Options holds options for the transport
+type Options struct {
+	// ConnectTimeout limits establishing the connection of a single attempt
+	ConnectTimeout time.Duration
+	// MaxRetries is the number of retries after the first attempt
+	MaxRetries int
+	// BaseDelay is the delay before the first retry, doubled for each further retry
+	BaseDelay time.Duration
+	// MaxDelay limits the delay between retries, including delays requested by Retry-After
+	MaxDelay time.Duration
+	// BreakerThreshold is the number of consecutive failed requests to a host after which its circuit breaker opens, 0 to disable
+	BreakerThreshold int
+	// BreakerCooldown is the time after which a request to a host with open circuit breaker is tried again
+	BreakerCooldown time.Duration
+	// OnBreakerChange is called when the circuit breaker of a host opens or closes
+	OnBreakerChange func(host string, open bool)
+}
+
+// DefaultOptions returns the options used if none are configured.
+func DefaultOptions() Options {
+	return Options{
+		ConnectTimeout:   10 * time.Second,
+		MaxRetries:       3,
+		BaseDelay:        500 * time.Millisecond,
+		MaxDelay:         30 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  time.Minute,
+	}
+}
+
+// Transport retries requests failing with network errors, 429 or 5xx.
+// The time of requests including their retries is limited by the timeout of the client.
+// Requests which are not idempotent are only retried after 429, as they were not processed then.
+// It is safe for concurrent use.
+type Transport struct {
+	base     http.RoundTripper
+	options  Options
+	breakers map[string]*breaker
+	mu       sync.Mutex
+	// sleep waits for the delay or until the request is canceled, replaced in tests
+	sleep func(r *http.Request, delay time.Duration) error
+}
+
+// New creates a transport.
+func New(options Options) *Transport {
+	base := http.DefaultTransport.(*http.Transport).Clone()
+	base.DialContext = (&net.Dialer{
+		Timeout:   options.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	base.TLSHandshakeTimeout = options.ConnectTimeout
+	return &Transport{
+		base:     base,
+		options:  options,
+		breakers: map[string]*breaker{},
+		sleep:    sleep,
+	}
+}
+
+// RoundTrip implements interface http.RoundTripper
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	b := t.breaker(r.URL.Host)
+	if !b.allow(time.Now()) {
+		return nil, fmt.Errorf("%s %s: %w", r.Method, r.URL.Redacted(), ErrCircuitOpen)
+	}
+
+	var resp *http.Response
+	var err error
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if r, err = rewind(r); err != nil {
+				resp = nil
+				break
+			}
+		}
+		resp, err = t.base.RoundTrip(r)
+		if attempt >= t.options.MaxRetries || !retryable(r, resp, err) {
+			break
+		}
+		delay := t.delay(attempt, resp)
+		if resp != nil {
+			// the body is discarded to reuse the connection
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+			_ = resp.Body.Close()
+		}
+		if err = t.sleep(r, delay); err != nil {
+			resp = nil
+			break
+		}
+	}
+	if r.Context().Err() != nil {
+		// canceled requests say nothing about the host
+		return resp, err
+	}
+	if changed, open := b.record(failed(resp, err), time.Now()); changed {
+		if t.options.OnBreakerChange != nil {
+			t.options.OnBreakerChange(r.URL.Host, open)
+		}
+	}
+	return resp, err
+}
+
+// retryable returns whether the attempt failed temporarily and the request can be sent again.
+func retryable(r *http.Request, resp *http.Response, err error) bool {
+	if r.Context().Err() != nil {
+		return false
+	}
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return false
+	}
+	if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	if !idempotent(r.Method) {
+		return false
+	}
+	return err != nil || resp.StatusCode >= 500
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// failed returns whether the request counts as failure for the circuit breaker.
+func failed(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+// rewind returns a copy of the request with a new body for sending it again.
+func rewind(r *http.Request) (*http.Request, error) {
+	clone := r.Clone(r.Context())
+	if r.GetBody != nil {
+		body, err := r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
+
+// delay returns the delay before the retry after the attempt, using the Retry-After header if present.
+// Without header, a random delay between half and the full exponential delay is used to spread retries.
+func (t *Transport) delay(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if delay, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			return min(delay, t.options.MaxDelay)
+		}
+	}
+	delay := t.options.BaseDelay << attempt
+	if delay <= 0 || delay > t.options.MaxDelay {
+		delay = t.options.MaxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryAfter parses the value of a Retry-After header, either in seconds or as date.
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+func sleep(r *http.Request, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+}
+
+func (t *Transport) breaker(host string) *breaker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, ok := t.breakers[host]
+	if !ok {
+		b = &breaker{threshold: t.options.BreakerThreshold, cooldown: t.options.BreakerCooldown}
+		t.breakers[host] = b
+	}
+	return b
+}
+
+// breaker stops requests to a host after consecutive failures.
+// After the cooldown, a single request is let through to test whether the host is available again.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	mu        sync.Mutex
+	failures  int
+	open      bool
+	// retryAt is the time from which the next request to the open host is let through
+	retryAt time.Time
+}
+
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true
+	}
+	if now.Before(b.retryAt) {
+		return false
+	}
+	b.retryAt = now.Add(b.cooldown)
+	return true
+}
+
+// record counts the result of a request and returns whether the breaker opened or closed.
+func (b *breaker) record(failed bool, now time.Time) (changed bool, open bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold <= 0 {
+		return false, false
+	}
+	if !failed {
+		b.failures = 0
+		changed = b.open
+		b.open = false
+		return changed, false
+	}
+	b.failures++
+	if !b.open && b.failures >= b.threshold {
+		b.open = true
+		b.retryAt = now.Add(b.cooldown)
+		return true, true
+	}
+	return false, b.open
+}
@@ -0,0 +1,144 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client which records the delays instead of waiting.
+func newTestClient(options Options, delays *[]time.Duration) *http.Client {
+	t := New(options)
+	t.sleep = func(r *http.Request, delay time.Duration) error {
+		*delays = append(*delays, delay)
+		return nil
+	}
+	return &http.Client{Transport: t}
+}
+
+func TestTransportRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		statuses []int
+		header   string
+		want     int
+		attempts int32
+	}{
+		{"success", http.MethodGet, []int{200}, "", 200, 1},
+		{"bad gateway", http.MethodGet, []int{502, 502, 200}, "", 200, 3},
+		{"retries exhausted", http.MethodPut, []int{503, 503, 503, 503, 200}, "", 503, 4},
+		{"client error", http.MethodGet, []int{404, 200}, "", 404, 1},
+		{"post not retried", http.MethodPost, []int{502, 200}, "", 502, 1},
+		{"post too many requests", http.MethodPost, []int{429, 200}, "1", 200, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempt := atomic.AddInt32(&attempts, 1)
+				if body := r.Header.Get("Content-Length"); r.Method != http.MethodGet && body != "4" {
+					t.Errorf("attempt %d has Content-Length %q, want body resent", attempt, body)
+				}
+				w.Header().Set("Retry-After", tt.header)
+				w.WriteHeader(tt.statuses[attempt-1])
+			}))
+			defer srv.Close()
+
+			var delays []time.Duration
+			client := newTestClient(Options{MaxRetries: 3, BaseDelay: time.Second, MaxDelay: time.Minute}, &delays)
+			var r *http.Request
+			if tt.method == http.MethodGet {
+				r, _ = http.NewRequest(tt.method, srv.URL, nil)
+			} else {
+				r, _ = http.NewRequest(tt.method, srv.URL, strings.NewReader("body"))
+			}
+			resp, err := client.Do(r)
+			if err != nil {
+				t.Fatalf("Do() error = %v", err)
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != tt.want || attempts != tt.attempts {
+				t.Errorf("Do() = %d after %d attempts, want %d after %d", resp.StatusCode, attempts, tt.want, tt.attempts)
+			}
+			for i, delay := range delays {
+				if max := time.Second << i; delay < max/2 || delay > max {
+					t.Errorf("delay %d = %v, want between %v and %v", i, delay, max/2, max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"Tue, 02 Jan 2024 10:00:30 GMT", 30 * time.Second, true},
+		{"Tue, 02 Jan 2024 09:00:00 GMT", 0, true},
+		{"soon", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, ok := retryAfter(tt.value, now)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTransportBreaker(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusBadGateway)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer srv.Close()
+
+	var changes []bool
+	var delays []time.Duration
+	client := newTestClient(Options{
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Hour,
+		OnBreakerChange: func(host string, open bool) {
+			changes = append(changes, open)
+		},
+	}, &delays)
+	get := func() error {
+		resp, err := client.Get(srv.URL)
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := get(); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Get() with open breaker error = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// let the next request through as if the cooldown passed
+	b := client.Transport.(*Transport).breaker(strings.TrimPrefix(srv.URL, "http://"))
+	b.retryAt = time.Now()
+	status.Store(http.StatusOK)
+	if err := get(); err != nil {
+		t.Fatalf("Get() after cooldown error = %v", err)
+	}
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("breaker changes = %v, want [true false]", changes)
+	}
+}
@@ -0,0 +1,447 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"firefly-iii-fix-ing/internal/audit"
+	"firefly-iii-fix-ing/internal/modules"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+const (
+	// auditSourceModules is recorded for changes of the modules which cannot be attributed to a single module
+	auditSourceModules    = "modules"
+	auditSourceTelegram   = "telegram"
+	auditSourceClassifier = "classifier"
+	auditSourceAlias      = "alias"
+	auditSourceDuplicates = "duplicates"
+	auditSourceRecurring  = "recurring"
+	auditSourceTransfers  = "transfers"
+	fieldSplits           = "splits"
+	fieldJournalID        = "transaction_journal_id"
+)
+
+// changeSources returns the module which changed a field of a journal.
+type changeSources func(journalID int, field string) string
+
+// singleSource attributes all changes to the same module.
+func singleSource(module string) changeSources {
+	return func(int, string) string {
+		return module
+	}
+}
+
+// traceSources maps journal IDs to the fields changed by each module, taken from the traces of the modules.
+type traceSources map[int]map[string]string
+
+func (s traceSources) add(journalID int, trace *modules.Trace) {
+	for _, step := range trace.Steps {
+		for _, change := range step.Changes {
+			if s[journalID] == nil {
+				s[journalID] = map[string]string{}
+			}
+			// later modules overwrite the changes of earlier ones
+			s[journalID][change.Field] = step.Module
+		}
+	}
+}
+
+// source implements changeSources.
+// Fields without trace, like the amount of a split journal, are attributed to the module which split the journal.
+func (s traceSources) source(journalID int, field string) string {
+	if module, ok := s[journalID][field]; ok {
+		return module
+	} else if module, ok = s[journalID][fieldSplits]; ok {
+		return module
+	}
+	return auditSourceModules
+}
+
+// rawTransaction is a transaction with the fields of its splits as returned by Firefly.
+type rawTransaction struct {
+	Id         string
+	GroupTitle string
+	// JournalIds contains the journals in the order returned by Firefly
+	JournalIds []int
+	Splits     map[int]map[string]json.RawMessage
+}
+
+func parseRawTransaction(content []byte) (*rawTransaction, error) {
+	var resp struct {
+		Data struct {
+			Id         string `json:"id"`
+			Attributes struct {
+				GroupTitle   string                       `json:"group_title"`
+				Transactions []map[string]json.RawMessage `json:"transactions"`
+			} `json:"attributes"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(content, &resp); err != nil {
+		return nil, err
+	}
+	t := &rawTransaction{
+		Id:         resp.Data.Id,
+		GroupTitle: resp.Data.Attributes.GroupTitle,
+		Splits:     map[int]map[string]json.RawMessage{},
+	}
+	for _, split := range resp.Data.Attributes.Transactions {
+		var journalID structs.Id
+		if err := json.Unmarshal(split[fieldJournalID], &journalID); err != nil {
+			return nil, fmt.Errorf("invalid journal ID: %w", err)
+		}
+		id, err := strconv.Atoi(string(journalID))
+		if err != nil {
+			return nil, fmt.Errorf("invalid journal ID: %w", err)
+		}
+		t.JournalIds = append(t.JournalIds, id)
+		t.Splits[id] = split
+	}
+	return t, nil
+}
+
+func (f *fireflyAPI) getRawTransaction(id int) (*rawTransaction, error) {
+	content, err := f.client.GetTransactionRaw(f.ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return parseRawTransaction(content)
+}
+
+// updateTransactionAudited updates the transaction like UpdateTransaction and records the changed fields in the audit journal.
+// Created journals are recorded with all their fields, so they are deleted when the change is reverted.
+func (f *fireflyAPI) updateTransactionAudited(id int, tu *structs.TransactionUpdate, sources changeSources) (*structs.TransactionRead, error) {
+	if f.journal == nil {
+		return f.UpdateTransaction(id, tu)
+	}
+	before, err := f.getRawTransaction(id)
+	if err != nil {
+		return nil, fmt.Errorf("could not read transaction #%d before update: %w", id, err)
+	}
+	content, err := f.putTransaction(id, tu)
+	if err != nil {
+		return nil, err
+	}
+	var updateResponse struct {
+		Data structs.TransactionRead `json:"data"`
+	}
+	if err = json.Unmarshal(content, &updateResponse); err != nil {
+		return nil, fmt.Errorf("transactions update #%d: %w", id, err)
+	}
+	after, err := parseRawTransaction(content)
+	if err != nil {
+		return nil, fmt.Errorf("transactions update #%d: %w", id, err)
+	}
+
+	if err = f.journal.Record(auditEntries(id, tu, before, after, sources)); err != nil {
+		log.Println("WARNING: could not record changes in audit journal:", err)
+	}
+	return &updateResponse.Data, nil
+}
+
+// deleteTransactionAudited deletes the transaction and records the fields of its journals in the audit journal.
+func (f *fireflyAPI) deleteTransactionAudited(id int, module string) error {
+	if f.journal == nil {
+		return f.deleteTransaction(strconv.Itoa(id))
+	}
+	before, err := f.getRawTransaction(id)
+	if err != nil {
+		return fmt.Errorf("could not read transaction #%d before deletion: %w", id, err)
+	}
+	if err = f.deleteTransaction(strconv.Itoa(id)); err != nil {
+		return err
+	}
+	entries := make([]audit.Entry, len(before.JournalIds))
+	for i, journalID := range before.JournalIds {
+		entries[i] = audit.Entry{
+			TransactionId: id,
+			JournalId:     journalID,
+			Module:        module,
+			Before:        before.Splits[journalID],
+			Deleted:       true,
+		}
+	}
+	if err = f.journal.Record(entries); err != nil {
+		log.Println("WARNING: could not record deletion in audit journal:", err)
+	}
+	return nil
+}
+
+// auditEntries returns one entry per journal and module with the fields which were changed by the update
+// and one entry per created journal.
+func auditEntries(id int, tu *structs.TransactionUpdate, before *rawTransaction, after *rawTransaction, sources changeSources) []audit.Entry {
+	var created []int
+	for _, journalID := range after.JournalIds {
+		if _, ok := before.Splits[journalID]; !ok {
+			created = append(created, journalID)
+		}
+	}
+
+	var entries []audit.Entry
+	// parentID is the journal preceding new splits in the update, which was split by a module
+	parentID := 0
+	for _, update := range tu.TransactionUpdates {
+		if update.JournalId == 0 {
+			if len(created) == 0 {
+				continue
+			}
+			// Firefly returns created journals in the order of the update
+			entries = append(entries, audit.Entry{
+				TransactionId: id,
+				JournalId:     created[0],
+				Module:        sources(parentID, fieldSplits),
+				After:         after.Splits[created[0]],
+				Created:       true,
+			})
+			created = created[1:]
+			continue
+		}
+		parentID = update.JournalId
+		splitBefore, okBefore := before.Splits[update.JournalId]
+		splitAfter, okAfter := after.Splits[update.JournalId]
+		if !okBefore || !okAfter {
+			continue
+		}
+		var fields map[string]json.RawMessage
+		content, _ := json.Marshal(update)
+		_ = json.Unmarshal(content, &fields)
+		delete(fields, fieldJournalID)
+
+		names := make([]string, 0, len(fields))
+		for field := range fields {
+			names = append(names, field)
+		}
+		sort.Strings(names)
+		byModule := map[string]*audit.Entry{}
+		for _, field := range names {
+			if equalJSON(splitBefore[field], splitAfter[field]) {
+				continue
+			}
+			module := sources(update.JournalId, field)
+			entry, ok := byModule[module]
+			if !ok {
+				entry = &audit.Entry{
+					TransactionId: id,
+					JournalId:     update.JournalId,
+					Module:        module,
+					Before:        map[string]json.RawMessage{},
+					After:         map[string]json.RawMessage{},
+				}
+				byModule[module] = entry
+			}
+			entry.Before[field] = splitBefore[field]
+			entry.After[field] = splitAfter[field]
+		}
+		moduleNames := make([]string, 0, len(byModule))
+		for module := range byModule {
+			moduleNames = append(moduleNames, module)
+		}
+		sort.Strings(moduleNames)
+		for _, module := range moduleNames {
+			entries = append(entries, *byModule[module])
+		}
+	}
+	return entries
+}
+
+// equalJSON compares two JSON values semantically. Missing values equal null.
+func equalJSON(a json.RawMessage, b json.RawMessage) bool {
+	var valueA, valueB any
+	if len(a) > 0 {
+		_ = json.Unmarshal(a, &valueA)
+	}
+	if len(b) > 0 {
+		_ = json.Unmarshal(b, &valueB)
+	}
+	return reflect.DeepEqual(valueA, valueB)
+}
+
+// revertTransaction restores the values before the given changes of a single transaction, starting with the newest change.
+// Fields which were changed again since are kept, as are journals which no longer exist. Created journals are deleted.
+// Deleted transactions cannot be restored, their values are only kept in the audit journal.
+// Returns the updated transaction, nil if nothing was changed, the IDs of the reverted entries
+// and the number of entries which were kept entirely. Only entries with at least one restored field count as reverted.
+func (f *fireflyAPI) revertTransaction(id int, entries []audit.Entry) (*structs.TransactionRead, []uint64, int, error) {
+	for _, entry := range entries {
+		if entry.Deleted {
+			return nil, nil, 0, fmt.Errorf("transaction was deleted by [%s] and cannot be restored, its values are recorded in entry %d of the audit journal", entry.Module, entry.Id)
+		}
+	}
+	current, err := f.getRawTransaction(id)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	changes := map[int]map[string]json.RawMessage{}
+	removed := map[int]bool{}
+	var reverted []uint64
+	kept := 0
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		state, ok := current.Splits[entry.JournalId]
+		if !ok {
+			log.Printf(">> Journal #%d no longer exists, skipping changes of [%s]", entry.JournalId, entry.Module)
+			kept++
+			continue
+		}
+		if entry.Created {
+			removed[entry.JournalId] = true
+			reverted = append(reverted, entry.Id)
+			continue
+		}
+		restored := false
+		for field, after := range entry.After {
+			if !equalJSON(state[field], after) {
+				log.Printf(">> %s of journal #%d was changed since [%s] changed it, keeping it", field, entry.JournalId, entry.Module)
+				continue
+			}
+			state[field] = entry.Before[field]
+			if changes[entry.JournalId] == nil {
+				changes[entry.JournalId] = map[string]json.RawMessage{}
+			}
+			changes[entry.JournalId][field] = entry.Before[field]
+			restored = true
+		}
+		if !restored {
+			kept++
+			continue
+		}
+		reverted = append(reverted, entry.Id)
+	}
+	if len(changes) == 0 && len(removed) == 0 {
+		return nil, reverted, kept, nil
+	}
+
+	// Firefly deletes journals missing from an update, so unchanged journals are kept by their journal ID
+	// and created journals are deleted by leaving them out
+	var splits []map[string]any
+	for _, journalID := range current.JournalIds {
+		if removed[journalID] {
+			continue
+		}
+		split := map[string]any{fieldJournalID: journalID}
+		for field, value := range changes[journalID] {
+			if len(value) == 0 {
+				value = json.RawMessage("null")
+			}
+			split[field] = value
+		}
+		splits = append(splits, split)
+	}
+	if len(splits) == 0 {
+		return nil, nil, 0, errors.New("all journals were created by the changes, delete the transaction instead")
+	}
+	content, err := f.putTransaction(id, map[string]any{
+		"apply_rules":   false,
+		"fire_webhooks": false,
+		"group_title":   current.GroupTitle,
+		"transactions":  splits,
+	})
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var updateResponse struct {
+		Data structs.TransactionRead `json:"data"`
+	}
+	if err = json.Unmarshal(content, &updateResponse); err != nil {
+		return nil, nil, 0, fmt.Errorf("transactions update #%d: %w", id, err)
+	}
+	return &updateResponse.Data, reverted, kept, nil
+}
+
+// revert reverts all changes recorded in the audit journal matching the filter, grouped by transaction.
+func (f *fireflyAPI) revert(filter audit.Filter, out io.Writer) error {
+	if f.journal == nil {
+		return fmt.Errorf("audit journal is disabled")
+	}
+	entries, err := f.journal.Entries(filter)
+	if err != nil {
+		return err
+	}
+	byTransaction := map[int][]audit.Entry{}
+	var ids []int
+	for _, entry := range entries {
+		if _, ok := byTransaction[entry.TransactionId]; !ok {
+			ids = append(ids, entry.TransactionId)
+		}
+		byTransaction[entry.TransactionId] = append(byTransaction[entry.TransactionId], entry)
+	}
+
+	failed := 0
+	for _, id := range ids {
+		updated, reverted, kept, err := f.revertTransaction(id, byTransaction[id])
+		if err != nil {
+			fmt.Fprintf(out, "#%d: ERROR: %v\n", id, err)
+			failed++
+			continue
+		}
+		if err = f.journal.MarkReverted(reverted); err != nil {
+			return fmt.Errorf("could not mark changes of #%d as reverted: %w", id, err)
+		}
+		if updated == nil {
+			fmt.Fprintf(out, "#%d: all fields were changed since, nothing to revert, %d changes kept\n", id, kept)
+		} else {
+			fmt.Fprintf(out, "#%d: %d changes reverted, %d kept\n", id, len(reverted), kept)
+		}
+	}
+	fmt.Fprintf(out, "%d transactions reverted, %d failed\n", len(ids)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d transactions could not be reverted", failed)
+	}
+	return nil
+}
+
+// RevertTransaction implements interface transactionUpdater.
+// All changes of the transaction recorded in the audit journal are reverted.
+func (f *fireflyAPI) RevertTransaction(id int) (*structs.TransactionRead, error) {
+	if f.journal == nil {
+		return nil, fmt.Errorf("audit journal is disabled")
+	}
+	entries, err := f.journal.Entries(audit.Filter{TransactionId: id})
+	if err != nil {
+		return nil, err
+	} else if len(entries) == 0 {
+		return nil, fmt.Errorf("no changes of transaction #%d recorded", id)
+	}
+	updated, reverted, kept, err := f.revertTransaction(id, entries)
+	if err != nil {
+		return nil, err
+	}
+	if kept > 0 {
+		log.Printf(">> %d changes of transaction #%d were kept, as their fields were changed since", kept, id)
+	}
+	if err = f.journal.MarkReverted(reverted); err != nil {
+		return nil, err
+	}
+	if updated == nil {
+		return f.getTransaction(id)
+	}
+	return updated, nil
+}
+
+// CanRevert implements interface transactionUpdater
+func (f *fireflyAPI) CanRevert(id int) bool {
+	if f.journal == nil {
+		return false
+	}
+	entries, err := f.journal.Entries(audit.Filter{TransactionId: id})
+	if err != nil {
+		log.Println("WARNING: could not read audit journal:", err)
+		return false
+	}
+	return len(entries) > 0
+}
+
+// Revert reverts the changes recorded in the audit journal which match the filter and writes a summary to out.
+func Revert(fireflyOptions FireflyOptions, moduleOptions ModuleOptions, filter audit.Filter, out io.Writer) error {
+	f, err := newCommandFireflyAPI(fireflyOptions, moduleOptions)
+	if err != nil {
+		return err
+	}
+	return f.revert(filter, out)
+}
@@ -0,0 +1,215 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"firefly-iii-fix-ing/internal/firefly"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// BackfillOptions holds options for running the modules over existing transactions
+type BackfillOptions struct {
+	// Start and End limit the dates of the transactions, zero for no limit
+	Start time.Time
+	End   time.Time
+	// AccountID restricts the backfill to the transactions of a single account, empty for all accounts
+	AccountID string
+	// Apply writes the updates to Firefly, otherwise they are only reported
+	Apply bool
+	// Interval is the minimum time between two updates
+	Interval time.Duration
+	// ProgressPath is the file recording the processed transactions, to resume an interrupted backfill
+	ProgressPath string
+}
+
+// backfillProgress records the transactions already processed by a backfill with the same filters.
+type backfillProgress struct {
+	Start     string          `json:"start"`
+	End       string          `json:"end"`
+	AccountID string          `json:"account_id"`
+	Done      map[string]bool `json:"done"`
+}
+
+type backfillUpdate struct {
+	transaction structs.WhTransactionRead
+	update      *structs.TransactionUpdate
+	sources     traceSources
+}
+
+// Backfill runs the modules over existing transactions, writes a summary of all changes to out
+// and, if requested, applies them.
+//
+// Applied transactions are recorded in the progress file, so they are skipped when the backfill is run again with the same filters.
+func Backfill(fireflyOptions FireflyOptions, moduleOptions ModuleOptions, options BackfillOptions, out io.Writer) error {
+	f, err := newCommandFireflyAPI(fireflyOptions, moduleOptions)
+	if err != nil {
+		return err
+	}
+	progress, err := loadBackfillProgress(options)
+	if err != nil {
+		return err
+	}
+
+	var updates []backfillUpdate
+	checked, skipped := 0, 0
+	err = f.listTransactions(options, func(t structs.TransactionRead) error {
+		if progress.Done[t.Id] {
+			skipped++
+			return nil
+		}
+		checked++
+		transaction, err := transactionToWebhook(&t)
+		if err != nil {
+			return err
+		}
+		if update, sources := f.processTransaction(transaction); update != nil {
+			// rules are not applied again, as their changes would be neither in the summary nor in the audit journal
+			update.ApplyRules = false
+			updates = append(updates, backfillUpdate{transaction: transaction, update: update, sources: sources})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, u := range updates {
+		writeBackfillSummary(out, u)
+	}
+	fmt.Fprintf(out, "%d transactions checked, %d to update, %d skipped as already processed\n", checked, len(updates), skipped)
+	if !options.Apply {
+		fmt.Fprintln(out, "Dry run, no changes applied.")
+		return nil
+	}
+
+	ticker := time.NewTicker(options.Interval)
+	defer ticker.Stop()
+	failed := 0
+	for i, u := range updates {
+		<-ticker.C
+		if _, err = f.updateTransactionAudited(u.transaction.Id, u.update, u.sources.source); err != nil {
+			fmt.Fprintf(out, "[%d/%d] #%d: ERROR: %v\n", i+1, len(updates), u.transaction.Id, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "[%d/%d] #%d updated\n", i+1, len(updates), u.transaction.Id)
+		progress.Done[strconv.Itoa(u.transaction.Id)] = true
+		if err = progress.save(options.ProgressPath); err != nil {
+			return fmt.Errorf("could not save progress: %w", err)
+		}
+	}
+	fmt.Fprintf(out, "%d transactions updated, %d failed\n", len(updates)-failed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d updates failed, run again to retry", failed)
+	}
+	return nil
+}
+
+// listTransactions calls fn for all transactions matching the filters of the backfill, reading all pages.
+func (f *fireflyAPI) listTransactions(options BackfillOptions, fn func(t structs.TransactionRead) error) error {
+	filter := firefly.TransactionFilter{Start: options.Start, End: options.End}
+	it := f.client.Transactions(filter)
+	if options.AccountID != "" {
+		it = f.client.AccountTransactions(options.AccountID, filter)
+	}
+	for it.Next(f.ctx) {
+		if err := fn(it.Item()); err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// transactionToWebhook converts a transaction into the structure sent by webhooks.
+func transactionToWebhook(t *structs.TransactionRead) (structs.WhTransactionRead, error) {
+	id, err := strconv.Atoi(t.Id)
+	if err != nil {
+		return structs.WhTransactionRead{}, err
+	}
+	splits, err := transactionToSplits(t)
+	if err != nil {
+		return structs.WhTransactionRead{}, err
+	}
+	return structs.WhTransactionRead{
+		Id:           id,
+		GroupTitle:   t.Attributes.GroupTitle,
+		Transactions: splits,
+	}, nil
+}
+
+// writeBackfillSummary writes the changed description and the names of other changed fields of each split.
+func writeBackfillSummary(out io.Writer, u backfillUpdate) {
+	descriptions := map[int]string{}
+	for _, split := range u.transaction.Transactions {
+		descriptions[split.JournalId] = split.Description
+	}
+	fmt.Fprintf(out, "#%d:\n", u.transaction.Id)
+	for _, update := range u.update.TransactionUpdates {
+		var fields map[string]any
+		content, _ := json.Marshal(update)
+		_ = json.Unmarshal(content, &fields)
+		delete(fields, "transaction_journal_id")
+		delete(fields, "description")
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		switch {
+		case update.JournalId == 0:
+			fmt.Fprintf(out, "    new split: '%s' %v\n", update.Description, names)
+		case update.Description == "" && len(names) == 0:
+			fmt.Fprintf(out, "    '%s' unchanged\n", descriptions[update.JournalId])
+		default:
+			fmt.Fprintf(out, "    '%s' -> '%s' %v\n", descriptions[update.JournalId], update.Description, names)
+		}
+	}
+}
+
+func loadBackfillProgress(options BackfillOptions) (*backfillProgress, error) {
+	progress := &backfillProgress{AccountID: options.AccountID, Done: map[string]bool{}}
+	if !options.Start.IsZero() {
+		progress.Start = options.Start.Format(time.DateOnly)
+	}
+	if !options.End.IsZero() {
+		progress.End = options.End.Format(time.DateOnly)
+	}
+	if options.ProgressPath == "" {
+		return progress, nil
+	}
+	content, err := os.ReadFile(options.ProgressPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return progress, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var saved backfillProgress
+	if err = json.Unmarshal(content, &saved); err != nil {
+		return nil, fmt.Errorf("could not parse backfill progress %s: %w", options.ProgressPath, err)
+	}
+	if saved.Start != progress.Start || saved.End != progress.End || saved.AccountID != progress.AccountID {
+		return nil, fmt.Errorf("backfill progress %s belongs to a backfill with other filters, remove it to start over", options.ProgressPath)
+	}
+	if saved.Done != nil {
+		progress.Done = saved.Done
+	}
+	return progress, nil
+}
+
+func (p *backfillProgress) save(path string) error {
+	if path == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0600)
+}
@@ -0,0 +1,177 @@
+package worker
+
+import (
+	"errors"
+	"firefly-iii-fix-ing/internal/firefly"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// minFireflyVersion is the oldest Firefly version whose API matches the structs
+	minFireflyVersion = "6.0.0"
+	// maxFireflyMajor is the newest major version of Firefly tested with the structs
+	maxFireflyMajor = 6
+)
+
+type diagnosticStatus uint8
+
+const (
+	diagnosticOK diagnosticStatus = iota
+	diagnosticWarning
+	diagnosticFailed
+)
+
+// diagnostic is the result of a single startup check.
+type diagnostic struct {
+	Name   string
+	Status diagnosticStatus
+	Detail string
+}
+
+// diagnosticsNotifier sends the report of the startup checks.
+type diagnosticsNotifier interface {
+	NotifyDiagnostics(diagnostics []diagnostic) error
+}
+
+// diagnose checks the connection to Firefly, its version and the permissions of the access token.
+// All checks only read. Write access is not probed, as requests to write could change data;
+// only demo users, which cannot change data, are detected by their role.
+func (f *fireflyAPI) diagnose() []diagnostic {
+	var diagnostics []diagnostic
+	add := func(name string, status diagnosticStatus, format string, args ...any) {
+		diagnostics = append(diagnostics, diagnostic{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	about, err := f.client.About(f.ctx)
+	if err != nil {
+		add("Firefly version", diagnosticFailed, "could not connect to %s: %v", f.fireflyBaseURL, err)
+		return diagnostics
+	}
+	switch version := strings.TrimPrefix(about.Version, "v"); {
+	case version == "" || version[0] < '0' || version[0] > '9':
+		// development builds are named after their branch
+		add("Firefly version", diagnosticWarning, "%s (API %s) is no release, webhooks may fail", about.Version, about.ApiVersion)
+	case compareVersions(version, minFireflyVersion) < 0:
+		add("Firefly version", diagnosticFailed, "%s (API %s) is not supported, at least %s is required", about.Version, about.ApiVersion, minFireflyVersion)
+	case majorVersion(version) > maxFireflyMajor:
+		add("Firefly version", diagnosticWarning, "%s (API %s) is newer than the tested versions, webhooks may fail", about.Version, about.ApiVersion)
+	default:
+		add("Firefly version", diagnosticOK, "%s (API %s)", about.Version, about.ApiVersion)
+	}
+
+	user, err := f.client.CurrentUser(f.ctx)
+	if err != nil {
+		add("Access token", diagnosticFailed, "%v", describeTokenError(err))
+		return diagnostics
+	}
+	switch {
+	case user.Attributes.Blocked:
+		add("Access token", diagnosticFailed, "user %s is blocked (%s)", user.Attributes.Email, user.Attributes.BlockedCode)
+	case user.Attributes.Role == "demo":
+		add("Access token", diagnosticFailed, "user %s is a demo user, which cannot change data", user.Attributes.Email)
+	default:
+		add("Access token", diagnosticOK, "user %s", user.Attributes.Email)
+	}
+
+	for _, resource := range []struct {
+		name string
+		path string
+	}{
+		{"Transactions", "/api/v1/transactions"},
+		{"Webhooks", "/api/v1/webhooks"},
+	} {
+		if err = f.checkReadable(resource.path); err != nil {
+			add(resource.name, diagnosticFailed, "cannot read: %v", describeTokenError(err))
+		} else {
+			add(resource.name, diagnosticOK, "read, write not verified")
+		}
+	}
+	return diagnostics
+}
+
+// checkReadable requests a single item of the endpoint.
+func (f *fireflyAPI) checkReadable(path string) error {
+	return f.client.Do(f.ctx, http.MethodGet, path, url.Values{"limit": {"1"}}, nil, nil)
+}
+
+// describeTokenError explains error responses caused by the access token.
+func describeTokenError(err error) error {
+	var responseError *firefly.Error
+	if !errors.As(err, &responseError) {
+		return err
+	}
+	switch responseError.StatusCode {
+	case http.StatusUnauthorized:
+		return fmt.Errorf("access token is invalid or expired (%w)", err)
+	case http.StatusForbidden:
+		return fmt.Errorf("access token lacks permission (%w)", err)
+	}
+	return err
+}
+
+// runDiagnostics logs the report of the startup checks and sends it via the notifier.
+// It returns an error if a check failed.
+func (f *fireflyAPI) runDiagnostics(notifier diagnosticsNotifier) error {
+	log.Println("Running startup diagnostics...")
+	diagnostics := f.diagnose()
+	var failed []string
+	for _, d := range diagnostics {
+		switch d.Status {
+		case diagnosticOK:
+			log.Printf(">> [OK] %s: %s", d.Name, d.Detail)
+		case diagnosticWarning:
+			log.Printf(">> [WARNING] %s: %s", d.Name, d.Detail)
+		case diagnosticFailed:
+			log.Printf(">> [FAILED] %s: %s", d.Name, d.Detail)
+			failed = append(failed, d.Name)
+		}
+	}
+	if err := notifier.NotifyDiagnostics(diagnostics); err != nil {
+		log.Println("WARNING: could not send diagnostics:", err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("startup diagnostics failed: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// compareVersions compares two versions like 6.1.2 by their numeric parts, ignoring suffixes like -beta.
+func compareVersions(a string, b string) int {
+	partsA := versionParts(a)
+	partsB := versionParts(b)
+	for i := 0; i < max(len(partsA), len(partsB)); i++ {
+		var partA, partB int
+		if i < len(partsA) {
+			partA = partsA[i]
+		}
+		if i < len(partsB) {
+			partB = partsB[i]
+		}
+		if partA != partB {
+			if partA < partB {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func majorVersion(version string) int {
+	return versionParts(version)[0]
+}
+
+func versionParts(version string) []int {
+	version, _, _ = strings.Cut(version, "-")
+	fields := strings.Split(version, ".")
+	parts := make([]int, len(fields))
+	for i, field := range fields {
+		parts[i], _ = strconv.Atoi(field)
+	}
+	return parts
+}
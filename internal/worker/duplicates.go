@@ -0,0 +1,153 @@
+package worker
+
+import (
+	"firefly-iii-fix-ing/internal/structs"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Actions for detected duplicates.
+const (
+	DuplicateActionOff    = "off"
+	DuplicateActionTag    = "tag"
+	DuplicateActionNotify = "notify"
+	DuplicateActionDelete = "delete"
+)
+
+const (
+	// duplicateTag is added to transactions detected as duplicate
+	duplicateTag = "duplicate"
+	// duplicateDateWindow is the maximum time between a transaction and its duplicate
+	duplicateDateWindow = 24 * time.Hour
+)
+
+// findDuplicate looks for an existing transaction which the transaction duplicates.
+//
+// Candidates are transactions of the same type on the same own account with the same amount.
+// If both have a SEPA end-to-end reference, it decides, otherwise they need to be on the same day
+// with the same counterparty, ignoring case, punctuation and numbers like terminal IDs.
+// Returns nil if there is no such transaction.
+func (f *fireflyAPI) findDuplicate(t *structs.TransactionRead) (*structs.TransactionRead, error) {
+	if len(t.Attributes.Transactions) != 1 {
+		return nil, nil
+	}
+	split := t.Attributes.Transactions[0]
+	var ownAccountID, counterparty string
+	switch split.Type {
+	case "withdrawal":
+		ownAccountID, counterparty = split.SourceId, split.DestinationName
+	case "deposit":
+		ownAccountID, counterparty = split.DestinationId, split.SourceName
+	default:
+		return nil, nil
+	}
+
+	id, err := strconv.Atoi(t.Id)
+	if err != nil {
+		return nil, err
+	}
+	date, err := time.Parse(time.RFC3339, split.Date)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := f.getAccountTransactions(ownAccountID, date.Add(-duplicateDateWindow), date.Add(duplicateDateWindow), split.Type)
+	if err != nil {
+		return nil, err
+	}
+	for i, candidate := range candidates {
+		if candidate.Id == t.Id || len(candidate.Attributes.Transactions) != 1 {
+			continue
+		}
+		candidateSplit := candidate.Attributes.Transactions[0]
+		if candidateSplit.Type != split.Type || !equalAmounts(candidateSplit.Amount, split.Amount) {
+			continue
+		}
+		// only report the newer transaction as duplicate of the older one
+		if candidateID, _ := strconv.Atoi(candidate.Id); candidateID > id {
+			continue
+		}
+		if split.SepaCtId != "" && candidateSplit.SepaCtId != "" {
+			if split.SepaCtId == candidateSplit.SepaCtId {
+				return &candidates[i], nil
+			}
+			continue
+		}
+		candidateCounterparty := candidateSplit.DestinationName
+		if split.Type == "deposit" {
+			candidateCounterparty = candidateSplit.SourceName
+		}
+		if strings.HasPrefix(candidateSplit.Date, date.Format(time.DateOnly)) &&
+			normalizeCounterparty(candidateCounterparty) == normalizeCounterparty(counterparty) {
+			return &candidates[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// handleDuplicate runs the configured action for a transaction detected as duplicate of original.
+func (f *fireflyAPI) handleDuplicate(t *structs.TransactionRead, original *structs.TransactionRead) error {
+	switch f.duplicateAction {
+	case DuplicateActionTag:
+		log.Printf(">> Tagging transaction as '%s'...", duplicateTag)
+		return f.tagTransaction(t, duplicateTag)
+	case DuplicateActionDelete:
+		log.Println(">> Deleting duplicate...")
+		id, err := strconv.Atoi(t.Id)
+		if err != nil {
+			return err
+		}
+		return f.deleteTransactionAudited(id, auditSourceDuplicates)
+	default:
+		log.Println(">> Sending duplicate notification...")
+		return f.notifManager.NotifyDuplicate(t, original, f.fireflyBaseURL)
+	}
+}
+
+func (f *fireflyAPI) tagTransaction(t *structs.TransactionRead, tag string) error {
+	id, err := strconv.Atoi(t.Id)
+	if err != nil {
+		return err
+	}
+	transactionUpdates := make([]structs.TransactionSplitUpdate, len(t.Attributes.Transactions))
+	for i, transactionSplit := range t.Attributes.Transactions {
+		journalID, err := strconv.Atoi(transactionSplit.JournalId)
+		if err != nil {
+			return err
+		}
+		tags := transactionSplit.Tags
+		if !slices.Contains(tags, tag) {
+			tags = append(slices.Clone(tags), tag)
+		}
+		transactionUpdates[i] = structs.TransactionSplitUpdate{JournalId: journalID, Tags: tags}
+	}
+	updateObj := &structs.TransactionUpdate{
+		ApplyRules:         false,
+		FireWebhooks:       false,
+		GroupTitle:         t.Attributes.GroupTitle,
+		TransactionUpdates: transactionUpdates,
+	}
+	_, err = f.updateTransactionAudited(id, updateObj, singleSource(auditSourceDuplicates))
+	return err
+}
+
+// DeleteTransaction implements interface transactionUpdater
+func (f *fireflyAPI) DeleteTransaction(id int) error {
+	return f.deleteTransactionAudited(id, auditSourceTelegram)
+}
+
+// normalizeCounterparty keeps only the words containing letters, in lower case.
+func normalizeCounterparty(name string) string {
+	var words []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if strings.IndexFunc(word, unicode.IsLetter) != -1 {
+			words = append(words, word)
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,164 @@
+package worker
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"firefly-iii-fix-ing/internal/firefly"
+	"firefly-iii-fix-ing/internal/modules"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// handleExplain runs the modules on a split without writing to Firefly and responds with the traces.
+//
+// The split is either posted as JSON like in webhooks, or an existing transaction is referenced with the query parameter id.
+// Requests need to authenticate with the Firefly access token as bearer token.
+func (f *fireflyAPI) handleExplain(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		if errClose := r.Body.Close(); errClose != nil {
+			log.Printf("WARNING: error closing request body: %v", errClose)
+		}
+	}()
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(f.fireflyAccessToken)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var splits []structs.WhTransactionSplit
+	switch r.Method {
+	case http.MethodGet:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(w, "invalid transaction ID", http.StatusBadRequest)
+			return
+		}
+		if splits, err = f.getTransactionSplits(id); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	case http.MethodPost:
+		var split structs.WhTransactionSplit
+		if err := json.NewDecoder(r.Body).Decode(&split); err != nil {
+			http.Error(w, "invalid split: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		splits = []structs.WhTransactionSplit{split}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log.Println()
+	log.Println("### BEGIN DRY RUN ###")
+	traces, err := explainSplits(f.moduleHandler, splits)
+	log.Println("####### DONE ########")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(traces); err != nil {
+		log.Println("WARNING: could not write response:", err)
+	}
+}
+
+func explainSplits(moduleHandler *modules.ModuleHandler, splits []structs.WhTransactionSplit) ([]*modules.Trace, error) {
+	traces := make([]*modules.Trace, len(splits))
+	for i := range splits {
+		trace, err := moduleHandler.Explain(&splits[i])
+		if err != nil {
+			return nil, err
+		}
+		traces[i] = trace
+	}
+	return traces, nil
+}
+
+// getTransactionSplits returns the splits of an existing transaction as they would be sent by a webhook.
+func (f *fireflyAPI) getTransactionSplits(id int) ([]structs.WhTransactionSplit, error) {
+	transaction, err := f.getTransaction(id)
+	if err != nil {
+		return nil, err
+	}
+	return transactionToSplits(transaction)
+}
+
+// transactionToSplits converts the splits of a transaction into splits as they would be sent by a webhook.
+func transactionToSplits(transaction *structs.TransactionRead) ([]structs.WhTransactionSplit, error) {
+	splits := make([]structs.WhTransactionSplit, len(transaction.Attributes.Transactions))
+	for i, split := range transaction.Attributes.Transactions {
+		journalID, err := strconv.Atoi(split.JournalId)
+		if err != nil {
+			return nil, err
+		}
+		splits[i] = structs.WhTransactionSplit{
+			JournalId:       journalID,
+			Type:            split.Type,
+			Date:            split.Date,
+			Amount:          split.Amount,
+			CurrencySymbol:  split.CurrencySymbol,
+			Description:     split.Description,
+			SourceId:        structs.Id(split.SourceId),
+			SourceName:      split.SourceName,
+			DestinationId:   structs.Id(split.DestinationId),
+			DestinationName: split.DestinationName,
+			CategoryName:    split.CategoryName,
+			Notes:           split.Notes,
+			Tags:            split.Tags,
+		}
+	}
+	return splits, nil
+}
+
+// newCommandFireflyAPI creates a fireflyAPI for running commands without webhook server and notifications.
+func newCommandFireflyAPI(fireflyOptions FireflyOptions, moduleOptions ModuleOptions) (*fireflyAPI, error) {
+	aliases, err := modules.NewAliasStore(moduleOptions.AliasesPath)
+	if err != nil {
+		return nil, err
+	}
+	moduleHandler, err := modules.NewModuleHandler(moduleOptions.RulesDir, aliases)
+	if err != nil {
+		return nil, err
+	}
+	baseURL := strings.TrimSuffix(fireflyOptions.BaseURL, "/")
+	return &fireflyAPI{
+		journal:            newJournal(moduleOptions),
+		fireflyBaseURL:     baseURL,
+		fireflyAccessToken: fireflyOptions.AccessToken,
+		client:             firefly.NewClient(baseURL, fireflyOptions.AccessToken, nil),
+		ctx:                context.Background(),
+		moduleHandler:      moduleHandler,
+	}, nil
+}
+
+// Explain runs the modules on a split without writing to Firefly.
+// The input is either the ID of an existing transaction or a split as JSON like in webhooks.
+func Explain(fireflyOptions FireflyOptions, moduleOptions ModuleOptions, input string) ([]*modules.Trace, error) {
+	f, err := newCommandFireflyAPI(fireflyOptions, moduleOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var splits []structs.WhTransactionSplit
+	if id, errID := strconv.Atoi(input); errID == nil {
+		if fireflyOptions.BaseURL == "" || fireflyOptions.AccessToken == "" {
+			return nil, fmt.Errorf("Firefly base URL and access token are required to explain transaction #%d", id)
+		}
+		if splits, err = f.getTransactionSplits(id); err != nil {
+			return nil, err
+		}
+	} else {
+		var split structs.WhTransactionSplit
+		if err = json.Unmarshal([]byte(input), &split); err != nil {
+			return nil, fmt.Errorf("input is neither a transaction ID nor a split: %w", err)
+		}
+		splits = []structs.WhTransactionSplit{split}
+	}
+	return explainSplits(f.moduleHandler, splits)
+}
@@ -1,77 +1,123 @@
 package worker
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
+	"firefly-iii-fix-ing/internal/audit"
+	"firefly-iii-fix-ing/internal/classifier"
+	"firefly-iii-fix-ing/internal/firefly"
 	"firefly-iii-fix-ing/internal/modules"
+	"firefly-iii-fix-ing/internal/queue"
 	"firefly-iii-fix-ing/internal/structs"
 	"fmt"
-	"io"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 const (
-	port            = 8822
-	webhookPath     = "/wh_fix_ing"
-	pathAccounts    = "/api/v1/accounts"
-	pathTransaction = "/api/v1/transactions"
-	pathWebhooks    = "/api/v1/webhooks"
-	pathCategories  = "/api/v1/categories"
+	explainPath = "/explain"
+	// numSuggestions is the number of category suggestions offered in notifications
+	numSuggestions = 3
 )
 
-type endpoints struct {
-	account      string
-	transactions string
-	webhooks     string
-	categories   string
-}
-
 type fireflyAPI struct {
 	srv                *http.Server
 	webhookURL         string
 	fireflyBaseURL     string
-	endpoints          endpoints
 	fireflyAccessToken string
-	targetWebhook      structs.WebhookAttributes
+	client             *firefly.Client
+	// ctx is the context of requests to Firefly, canceled if jobs do not finish in time on shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	// stopping is closed on shutdown to stop the queue and the readiness probes
+	stopping         chan struct{}
+	webhooks         []*webhook
+	webhookRoutes    map[string]*webhook
+	readiness        *readiness
+	queue            *queue.Queue
+	queueWorkers     int
+	queueMaxAttempts int
+	queueWake        chan struct{}
+	// queueDone is closed when the queue stopped and all jobs in progress finished
+	queueDone          chan struct{}
 	moduleHandler      *modules.ModuleHandler
+	journal            *audit.Journal
 	notifManager       transactionNotifier
+	classifier         *classifier.Classifier
+	autoApplyThreshold float64
+	autoMergeTransfers bool
+	transferDateWindow time.Duration
+	duplicateAction    string
+	// mergeMu serializes merging transfers
+	mergeMu sync.Mutex
+	// recurringHistory caches the withdrawals of each account searched for recurring payments
+	recurringHistory accountHistory
+	// recentUpdates holds the time of the last update of each transaction by this service, to prevent loops
+	recentUpdates   map[int]time.Time
+	recentUpdatesMu sync.Mutex
 }
 
 type transactionNotifier interface {
-	NotifyNewTransaction(t *structs.TransactionRead, fireflyBaseURL string, categories []structs.CategoryRead) error
+	NotifyNewTransaction(t *structs.TransactionRead, fireflyBaseURL string, categories []structs.CategoryRead, suggestions []classifier.Prediction) error
+	NotifyTransferCandidate(t *structs.TransactionRead, counterpart *structs.TransactionRead, fireflyBaseURL string) error
+	NotifyDuplicate(t *structs.TransactionRead, original *structs.TransactionRead, fireflyBaseURL string) error
+	NotifyRecurringPayment(t *structs.TransactionRead, repeatFreq string, fireflyBaseURL string) error
+	NotifyTransactionDestroyed(id string) error
+	NotifyError(err error) error
+	NotifyReadiness(ready bool, webhookURL string, cause error) error
 }
 
-func newFireflyAPI(fireflyOptions FireflyOptions, moduleHandler *modules.ModuleHandler, notifManager transactionNotifier) *fireflyAPI {
+func newFireflyAPI(fireflyOptions FireflyOptions, moduleHandler *modules.ModuleHandler, journal *audit.Journal, notifManager transactionNotifier, c *classifier.Classifier, classifierOptions ClassifierOptions, transferOptions TransferOptions, duplicateOptions DuplicateOptions, queueOptions QueueOptions, httpClient *http.Client, serverOptions ServerOptions) *fireflyAPI {
+	webhookURL := serverOptions.PublicURL
+	if webhookURL == "" {
+		webhookURL = fireflyOptions.BaseURL + serverOptions.WebhookPath
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	f := fireflyAPI{
-		webhookURL:     fireflyOptions.BaseURL + webhookPath,
-		fireflyBaseURL: fireflyOptions.BaseURL,
-		endpoints: endpoints{
-			account:      fireflyOptions.BaseURL + pathAccounts,
-			transactions: fireflyOptions.BaseURL + pathTransaction,
-			webhooks:     fireflyOptions.BaseURL + pathWebhooks,
-			categories:   fireflyOptions.BaseURL + pathCategories,
-		},
+		webhookURL:         strings.TrimSuffix(webhookURL, "/"),
+		fireflyBaseURL:     fireflyOptions.BaseURL,
 		fireflyAccessToken: fireflyOptions.AccessToken,
-		targetWebhook: structs.WebhookAttributes{
-			Active:   true,
-			Title:    "Fix ING transaction descriptions from Importer",
-			Response: "TRANSACTIONS",
-			Delivery: "JSON",
-			Trigger:  "STORE_TRANSACTION",
-			Url:      fireflyOptions.BaseURL + webhookPath,
-		},
-		moduleHandler: moduleHandler,
-		notifManager:  notifManager,
-	}
+		client:             firefly.NewClient(fireflyOptions.BaseURL, fireflyOptions.AccessToken, httpClient),
+		ctx:                ctx,
+		cancel:             cancel,
+		stopping:           make(chan struct{}),
+		moduleHandler:      moduleHandler,
+		journal:            journal,
+		notifManager:       notifManager,
+		classifier:         c,
+		autoApplyThreshold: classifierOptions.AutoApplyThreshold,
+		autoMergeTransfers: transferOptions.AutoMerge,
+		transferDateWindow: time.Duration(transferOptions.DateWindowDays) * 24 * time.Hour,
+		duplicateAction:    duplicateOptions.Action,
+		recentUpdates:      map[int]time.Time{},
+		queue:              queue.New(queueOptions.Path),
+		queueWorkers:       queueOptions.Workers,
+		queueMaxAttempts:   queueOptions.MaxAttempts,
+		queueWake:          make(chan struct{}, 1),
+		queueDone:          make(chan struct{}),
+	}
+	f.webhooks = f.newWebhooks(f.webhookURL)
+	f.readiness = newReadiness(f.webhookURL)
 	handler := http.NewServeMux()
-	handler.HandleFunc("/", f.handleNewTransactionWebhook)
+	handler.HandleFunc(explainPath, f.handleExplain)
+	handler.HandleFunc(healthzPath, f.readiness.handleHealthz)
+	handler.HandleFunc(statusPath, f.readiness.handleStatus)
+	// the webhooks for updated and deleted transactions are received below the webhook path,
+	// like the healthz endpoint probed at the public webhook URL
+	webhookPath := "/" + strings.Trim(serverOptions.WebhookPath, "/")
+	f.webhookRoutes = newWebhookRoutes(webhookPath, f.webhooks)
+	handler.HandleFunc("/", f.handleWebhooks)
+	if webhookPath != "/" {
+		handler.HandleFunc(webhookPath+healthzPath, f.readiness.handleHealthz)
+	}
 
 	f.srv = &http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
+		Addr:    net.JoinHostPort(serverOptions.BindAddress, strconv.Itoa(serverOptions.Port)),
 		Handler: handler,
 	}
 	return &f
@@ -82,185 +128,56 @@ func (f *fireflyAPI) Listen() error {
 		return errors.New("please set callbacks before calling fireflyApi::Listen")
 	}
 
-	go func() {
-		time.Sleep(1 * time.Second) // give a little time for httpServer to be up and running
-		client := http.Client{Timeout: 10 * time.Second}
-		resp, err := client.Get(f.webhookURL)
-		//goland:noinspection GoUnhandledErrorResult
-		if err != nil {
-			log.Fatalln("Error validating httpServer connection:", err)
-		} else if resp.StatusCode != 200 {
-			log.Fatalln("Could not validate connection from webhook URL ", f.webhookURL)
-		}
-		log.Println("Connection to", f.webhookURL, "validated")
-		log.Println("Ready to accept connections!")
-	}()
+	// the public URL is checked, as Firefly sends webhooks there
+	go f.checkReadiness()
+
+	log.Printf("Processing webhooks with %d workers...", f.queueWorkers)
+	go f.processQueue()
 
 	// start webserver
-	log.Printf("Starting httpServer on port %d...", port)
+	log.Printf("Starting httpServer on %s...", f.srv.Addr)
 	return f.srv.ListenAndServe()
 }
 
-func (f *fireflyAPI) handleNewTransactionWebhook(_ http.ResponseWriter, r *http.Request) {
-	var target struct {
-		Version string                    `json:"version"`
-		Data    structs.WhTransactionRead `json:"content"`
-	}
-	defer func() {
-		if errClose := r.Body.Close(); errClose != nil {
-			log.Printf("WARNING: error closing request body: %v", errClose)
-		}
-	}()
-	if err := json.NewDecoder(r.Body).Decode(&target); err != nil || target.Version == "" {
-		log.Println("WARNING: received request with invalid body structure")
-		return
-	}
-
-	log.Println()
-	log.Println("### BEGIN NEW WEBHOOK ###")
-
-	if err := f.checkAndUpdateTransaction(target.Data); err != nil {
-		log.Println(">> WARNING: error updating transactions:", err)
-	}
-	log.Println("######### DONE ##########")
-}
-
-func (f *fireflyAPI) createOrUpdateWebhook() (url string, err error) {
-	var result *structs.WhUrlResult
-	result, err = f.getWebhook()
-	if err != nil {
-		return
-	}
-	if result.Exists && !result.NeedsUpdate {
-		url = result.Wh.Attributes.Url
-		return
-	}
-
-	var method string
-	var endpoint string
-	if !result.Exists {
-		// create
-		log.Printf("webhook with title '%s' does not exist, creating a new one", f.targetWebhook.Title)
-		method = "POST"
-		endpoint = f.endpoints.webhooks
-	} else {
-		// update
-		log.Printf("webhook with title '%s' exists, but requires update", f.targetWebhook.Title)
-		method = "PUT"
-		endpoint = f.endpoints.webhooks + "/" + result.Wh.Id
-	}
-	var body []byte
-	body, err = json.Marshal(f.targetWebhook)
-	if err != nil {
-		return
-	}
-	var resp *http.Response
-	resp, err = f.request(method, endpoint, bytes.NewBuffer(body))
-	if err != nil {
-		return
-	}
-	var resultWebhook struct {
-		Data    structs.WebhookRead `json:"data"`
-		Message string              `json:"message"`
-	}
-	defer func() {
-		err = errors.Join(err, resp.Body.Close())
-	}()
-	respBytes, _ := io.ReadAll(resp.Body)
-	if err = json.Unmarshal(respBytes, &resultWebhook); err != nil {
-		return
-	}
-	if resp.StatusCode != http.StatusOK && resultWebhook.Message != "" {
-		err = errors.New(resultWebhook.Message)
-		return
-	}
-	if resultWebhook.Data.Attributes.Title == "" {
-		err = errors.New("unknown error")
-		return
-	}
-	url = resultWebhook.Data.Attributes.Url
-	return
-}
-
-func (f *fireflyAPI) getWebhook() (*structs.WhUrlResult, error) {
-	wh, err := f.findWebhookByTitle()
-	if err != nil {
-		return nil, err
-	}
-	if wh == nil {
-		return &structs.WhUrlResult{
-			Exists:      false,
-			NeedsUpdate: false,
-		}, nil
-	}
-
-	if !wh.Attributes.Active ||
-		wh.Attributes.Delivery != f.targetWebhook.Delivery ||
-		wh.Attributes.Response != f.targetWebhook.Response ||
-		wh.Attributes.Trigger != f.targetWebhook.Trigger ||
-		wh.Attributes.Url != f.targetWebhook.Url {
-		return &structs.WhUrlResult{
-			Exists:      true,
-			NeedsUpdate: true,
-			Wh:          wh,
-		}, nil
-	}
-
-	return &structs.WhUrlResult{
-		Exists:      true,
-		NeedsUpdate: false,
-		Wh:          wh,
-	}, nil
-}
-
-func (f *fireflyAPI) findWebhookByTitle() (wh *structs.WebhookRead, err error) {
-	var resp *http.Response
-	resp, err = f.request("GET", f.endpoints.webhooks, nil)
-	if err != nil {
-		return
-	}
-	if resp.StatusCode == http.StatusNotFound {
-		err = errors.New("404 not found")
-		return
-	} else if resp.StatusCode != http.StatusOK {
-		err = fmt.Errorf("got invalid status code %d", resp.StatusCode)
-		return
-	}
-	var webhooksResponse struct {
-		Webhooks []structs.WebhookRead `json:"data"`
-	}
-	defer func() {
-		err = errors.Join(err, resp.Body.Close())
-	}()
-	if err = json.NewDecoder(resp.Body).Decode(&webhooksResponse); err != nil {
-		return
-	}
-
-	for _, webhook := range webhooksResponse.Webhooks {
-		if webhook.Attributes.Title == f.targetWebhook.Title {
-			wh = &webhook
-			return
-		}
-	}
-	return
-}
-
-func (f *fireflyAPI) checkAndUpdateTransaction(t structs.WhTransactionRead) error {
+// processTransaction runs the modules on all splits of the transaction.
+// Returns nil if no module applied, otherwise also the modules which changed each field.
+func (f *fireflyAPI) processTransaction(t structs.WhTransactionRead) (*structs.TransactionUpdate, traceSources) {
+	// Firefly deletes journals missing from an update, so unchanged splits are kept by their journal ID
 	var transactionSplitUpdates []structs.TransactionSplitUpdate
+	sources := traceSources{}
+	didUpdate := false
 	for i := range t.Transactions {
 		transactionInner := t.Transactions[i]
 		log.Println(">> ID: #" + strconv.Itoa(t.Id))
 		log.Println(">> Description: '" + transactionInner.Description + "'")
-		update, err := f.moduleHandler.Process(&transactionInner)
+		trace, err := f.moduleHandler.Explain(&transactionInner)
 		if err != nil {
 			log.Println("WARNING: error running modules:", err)
-		} else if update != nil {
-			transactionSplitUpdates = append(transactionSplitUpdates, *update)
 		}
+		if err == nil && trace.Updates != nil {
+			transactionSplitUpdates = append(transactionSplitUpdates, trace.Updates...)
+			sources.add(transactionInner.JournalId, trace)
+			didUpdate = true
+		} else {
+			transactionSplitUpdates = append(transactionSplitUpdates, structs.TransactionSplitUpdate{JournalId: transactionInner.JournalId})
+		}
+	}
+	if !didUpdate {
+		return nil, nil
 	}
+	return &structs.TransactionUpdate{
+		ApplyRules:         true,
+		FireWebhooks:       false,
+		GroupTitle:         groupTitle(t, transactionSplitUpdates),
+		TransactionUpdates: transactionSplitUpdates,
+	}, sources
+}
 
+// checkAndUpdateTransaction runs the modules on a new transaction and the checks for duplicates, transfers,
+// recurring payments and categories, which may send notifications.
+func (f *fireflyAPI) checkAndUpdateTransaction(t structs.WhTransactionRead, steps *jobSteps) error {
 	var resultTransaction *structs.TransactionRead
-	if len(transactionSplitUpdates) == 0 {
+	if updateObj, sources := f.processTransaction(t); updateObj == nil {
 		log.Println(">>>> No fix applied")
 		transaction, err := f.getTransaction(t.Id)
 		if err == nil {
@@ -270,19 +187,50 @@ func (f *fireflyAPI) checkAndUpdateTransaction(t structs.WhTransactionRead) erro
 		}
 	} else {
 		// update transaction
-		updateObj := structs.TransactionUpdate{
-			ApplyRules:         true,
-			FireWebhooks:       false,
-			GroupTitle:         transactionSplitUpdates[0].Description,
-			TransactionUpdates: transactionSplitUpdates,
-		}
-		updateResponse, err := f.UpdateTransaction(t.Id, &updateObj)
+		updateResponse, err := f.updateTransactionAudited(t.Id, updateObj, sources.source)
 		if err != nil {
 			return err
 		}
 		resultTransaction = updateResponse
 	}
 
+	if f.duplicateAction != DuplicateActionOff {
+		original, err := f.findDuplicate(resultTransaction)
+		if err != nil {
+			log.Println("WARNING: could not check for duplicates:", err)
+		} else if original != nil {
+			log.Printf(">> Transaction #%s looks like duplicate of #%s", resultTransaction.Id, original.Id)
+			return f.handleDuplicate(resultTransaction, original)
+		}
+	}
+
+	counterpart, err := f.findTransferCounterpart(resultTransaction)
+	if err != nil {
+		log.Println("WARNING: could not check for internal transfer:", err)
+	} else if counterpart != nil {
+		log.Printf(">> Transaction #%s looks like internal transfer with #%s", resultTransaction.Id, counterpart.Id)
+		if f.autoMergeTransfers {
+			counterpartID, err := strconv.Atoi(counterpart.Id)
+			if err != nil {
+				return err
+			}
+			if _, err = f.mergeTransfer(t.Id, counterpartID); errors.Is(err, errAlreadyMerged) {
+				log.Println(">> Already merged by the job of the counterpart, skipping")
+				return nil
+			} else if err != nil {
+				return err
+			}
+			log.Println(">> Success.")
+			return nil
+		}
+		log.Println(">> Sending transfer notification...")
+		return f.notifManager.NotifyTransferCandidate(resultTransaction, counterpart, f.fireflyBaseURL)
+	}
+
+	if err = steps.once("recurring", func() error { return f.checkRecurring(resultTransaction) }); err != nil {
+		log.Println("WARNING: could not check for recurring payments:", err)
+	}
+
 	if resultTransaction.Attributes.Transactions[0].CategoryName != "" {
 		log.Println(">> categories already set, not sending notification")
 		return nil
@@ -294,8 +242,21 @@ func (f *fireflyAPI) checkAndUpdateTransaction(t structs.WhTransactionRead) erro
 		log.Println("WARNING: could not retrieve category names:", err)
 	}
 
+	suggestions := f.suggestCategories(resultTransaction, categories)
+	if len(suggestions) > 0 {
+		log.Printf(">> Suggested category '%s' (%.0f%%)", suggestions[0].Category, suggestions[0].Probability*100)
+		if f.autoApplyThreshold > 0 && suggestions[0].Probability >= f.autoApplyThreshold {
+			log.Println(">> Applying suggested category automatically...")
+			if _, err = f.setTransactionCategory(resultTransaction, suggestions[0].Category, auditSourceClassifier); err == nil {
+				log.Println(">> Success.")
+				return nil
+			}
+			log.Println("WARNING: could not apply suggested category:", err)
+		}
+	}
+
 	log.Println(">> Sending notification...")
-	err = f.notifManager.NotifyNewTransaction(resultTransaction, f.fireflyBaseURL, categories)
+	err = f.notifManager.NotifyNewTransaction(resultTransaction, f.fireflyBaseURL, categories, suggestions)
 	if err == nil {
 		log.Println(">> Success.")
 	} else {
@@ -304,76 +265,58 @@ func (f *fireflyAPI) checkAndUpdateTransaction(t structs.WhTransactionRead) erro
 	return nil
 }
 
-func (f *fireflyAPI) getTransaction(id int) (data *structs.TransactionRead, err error) {
-	endpoint := fmt.Sprintf("%s/%d", f.endpoints.transactions, id)
-	var resp *http.Response
-	resp, err = f.request("GET", endpoint, nil)
-	if err != nil {
-		return
-	}
-	var respTransaction struct {
-		Data structs.TransactionRead `json:"data"`
+// groupTitle returns the title for the updated transaction, which is required by Firefly if it has multiple splits.
+// The existing title is kept, otherwise the first updated description is used.
+func groupTitle(t structs.WhTransactionRead, updates []structs.TransactionSplitUpdate) string {
+	if t.GroupTitle != "" {
+		return t.GroupTitle
 	}
-	defer func() {
-		err = errors.Join(err, resp.Body.Close())
-	}()
-	if err = json.NewDecoder(resp.Body).Decode(&respTransaction); err != nil {
-		return
+	for _, update := range updates {
+		if update.Description != "" {
+			return update.Description
+		}
 	}
-	data = &respTransaction.Data
-	return
+	return ""
 }
 
-func (f *fireflyAPI) getCategories() (data []structs.CategoryRead, err error) {
-	var resp *http.Response
-	resp, err = f.request("GET", f.endpoints.categories, nil)
-	if err != nil {
-		return
-	}
-	var categoriesResp struct {
-		Data []structs.CategoryRead `json:"data"`
-	}
-	defer func() {
-		err = errors.Join(err, resp.Body.Close())
-	}()
-	if err = json.NewDecoder(resp.Body).Decode(&categoriesResp); err != nil {
-		return
-	}
-	data = categoriesResp.Data
-	return
+func (f *fireflyAPI) getTransaction(id int) (*structs.TransactionRead, error) {
+	return f.client.GetTransaction(f.ctx, id)
+}
+
+// getAllTransactions returns all transactions, reading all pages.
+func (f *fireflyAPI) getAllTransactions() ([]structs.TransactionRead, error) {
+	return f.client.Transactions(firefly.TransactionFilter{}).All(f.ctx)
 }
 
-func (f *fireflyAPI) UpdateTransaction(id int, tu *structs.TransactionUpdate) (data *structs.TransactionRead, err error) {
-	endpoint := fmt.Sprintf("%s/%d", f.endpoints.transactions, id)
+// getCategories returns all categories, reading all pages.
+func (f *fireflyAPI) getCategories() ([]structs.CategoryRead, error) {
+	return f.client.Categories().All(f.ctx)
+}
 
-	var updateObjBytes []byte
-	updateObjBytes, err = json.Marshal(tu)
+// UpdateTransaction updates the transaction with the given ID.
+// The splits in tu replace the splits of the transaction: splits without journal ID are created,
+// existing journals which are not contained in tu are deleted by Firefly.
+func (f *fireflyAPI) UpdateTransaction(id int, tu *structs.TransactionUpdate) (*structs.TransactionRead, error) {
+	content, err := f.putTransaction(id, tu)
 	if err != nil {
-		return
+		return nil, err
 	}
-	log.Println(">> Communicating with Firefly-III...")
-	var resp *http.Response
-	resp, err = f.request("PUT", endpoint, bytes.NewBuffer(updateObjBytes))
+	transaction, err := firefly.ParseTransaction(content)
 	if err != nil {
-		return
-	} else if resp.StatusCode != http.StatusOK {
-		err = errors.New(parseResponseError(resp))
-		return
+		return nil, fmt.Errorf("transactions update #%d: %w", id, err)
 	}
+	return transaction, nil
+}
 
-	var updateResponse struct {
-		Data structs.TransactionRead `json:"data"`
-	}
-	defer func() {
-		err = errors.Join(err, resp.Body.Close())
-	}()
-	respBytes, _ := io.ReadAll(resp.Body)
-	if err = json.Unmarshal(respBytes, &updateResponse); err != nil {
-		err = fmt.Errorf("transactions update #%d: %w", id, err)
-		return
-	}
-	data = &updateResponse.Data
-	return
+// putTransaction sends the update of the transaction with the given ID and returns the response body.
+func (f *fireflyAPI) putTransaction(id int, body any) ([]byte, error) {
+	log.Println(">> Communicating with Firefly-III...")
+	content, err := f.client.UpdateTransactionRaw(f.ctx, id, body)
+	if err != nil {
+		return nil, err
+	}
+	f.markUpdated(id)
+	return content, nil
 }
 
 // FireflyBaseURL implements interface transactionUpdater
@@ -381,21 +324,49 @@ func (f *fireflyAPI) FireflyBaseURL() string {
 	return f.fireflyBaseURL
 }
 
-// SetTransactionCategory implements interface transactionUpdater
+// SetTransactionCategory implements interface transactionUpdater.
+// The choice is used to train the classifier.
 func (f *fireflyAPI) SetTransactionCategory(id int, categoryName string) (*structs.TransactionRead, error) {
 	transaction, err := f.getTransaction(id)
 	if err != nil {
 		return nil, err
 	}
+	updated, err := f.setTransactionCategory(transaction, categoryName, auditSourceTelegram)
+	if err != nil {
+		return nil, err
+	}
+	for _, split := range transaction.Attributes.Transactions {
+		f.classifier.Learn(newClassifierSample(split.Description, split.SourceName, split.DestinationName, split.Type), categoryName)
+	}
+	if err = f.classifier.Save(); err != nil {
+		log.Println("WARNING: could not save classifier model:", err)
+	}
+	return updated, nil
+}
+
+// GetTransaction implements interface transactionUpdater
+func (f *fireflyAPI) GetTransaction(id int) (*structs.TransactionRead, error) {
+	return f.getTransaction(id)
+}
+
+// SetTransactionCounterparty implements interface transactionUpdater.
+// Depending on the type of each split, its source or destination name is replaced.
+func (f *fireflyAPI) SetTransactionCounterparty(transaction *structs.TransactionRead, name string) (*structs.TransactionRead, error) {
+	id, err := strconv.Atoi(transaction.Id)
+	if err != nil {
+		return nil, err
+	}
 	transactionUpdates := make([]structs.TransactionSplitUpdate, len(transaction.Attributes.Transactions))
 	for i, transactionSplit := range transaction.Attributes.Transactions {
-		journalID, err := strconv.ParseInt(transactionSplit.JournalId, 10, 64)
+		journalID, err := strconv.Atoi(transactionSplit.JournalId)
 		if err != nil {
 			return nil, err
 		}
-		transactionUpdates[i] = structs.TransactionSplitUpdate{
-			JournalId:    int(journalID),
-			CategoryName: categoryName,
+		transactionUpdates[i] = structs.TransactionSplitUpdate{JournalId: journalID}
+		if transactionSplit.Type == "deposit" {
+			transactionUpdates[i].SourceName = name
+		} else {
+			transactionUpdates[i].DestinationName = name
 		}
 	}
 	updateObj := &structs.TransactionUpdate{
@@ -404,44 +375,54 @@ func (f *fireflyAPI) SetTransactionCategory(id int, categoryName string) (*struc
 		GroupTitle:         transaction.Attributes.GroupTitle,
 		TransactionUpdates: transactionUpdates,
 	}
-	return f.UpdateTransaction(id, updateObj)
-}
-
-type responseError struct {
-	Message string `json:"message"`
+	return f.updateTransactionAudited(id, updateObj, singleSource(auditSourceAlias))
 }
 
-func parseResponseError(r *http.Response) string {
-	var responseError responseError
-
-	defer func() {
-		if errClose := r.Body.Close(); errClose != nil {
-			log.Printf("WARNING: error closing response body: %v", errClose)
-		}
-	}()
-	respBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		return fmt.Sprintf("could not read response body: %v", err)
-	}
-	if err = json.Unmarshal(respBytes, &responseError); err != nil {
-		return fmt.Sprintf("could not decode response: %v ('%s')", err, string(respBytes))
+// suggestCategories returns the most probable categories for the first split of the transaction.
+// Only categories which still exist are returned.
+func (f *fireflyAPI) suggestCategories(t *structs.TransactionRead, categories []structs.CategoryRead) []classifier.Prediction {
+	if len(t.Attributes.Transactions) == 0 {
+		return nil
 	}
-	if responseError.Message != "" {
-		return responseError.Message
+	split := t.Attributes.Transactions[0]
+	predictions := f.classifier.Predict(newClassifierSample(split.Description, split.SourceName, split.DestinationName, split.Type), len(categories))
+	var suggestions []classifier.Prediction
+	for _, prediction := range predictions {
+		if len(suggestions) == numSuggestions {
+			break
+		}
+		for _, category := range categories {
+			if category.Attributes.Name == prediction.Category {
+				suggestions = append(suggestions, prediction)
+				break
+			}
+		}
 	}
-	return fmt.Sprintf("Unknown error ('%s')", string(respBytes))
+	return suggestions
 }
 
-func (f *fireflyAPI) request(method string, url string, body io.Reader) (*http.Response, error) {
-	r, err := http.NewRequest(method, url, body)
+// setTransactionCategory sets the category of all splits, recording source as the module in the audit journal.
+func (f *fireflyAPI) setTransactionCategory(transaction *structs.TransactionRead, categoryName string, source string) (*structs.TransactionRead, error) {
+	id, err := strconv.Atoi(transaction.Id)
 	if err != nil {
 		return nil, err
 	}
-	r.Header.Add("Authorization", "Bearer "+f.fireflyAccessToken)
-	if method == http.MethodPut || method == http.MethodPost {
-		r.Header.Add("Content-Type", "application/json")
+	transactionUpdates := make([]structs.TransactionSplitUpdate, len(transaction.Attributes.Transactions))
+	for i, transactionSplit := range transaction.Attributes.Transactions {
+		journalID, err := strconv.ParseInt(transactionSplit.JournalId, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		transactionUpdates[i] = structs.TransactionSplitUpdate{
+			JournalId:    int(journalID),
+			CategoryName: categoryName,
+		}
+	}
+	updateObj := &structs.TransactionUpdate{
+		ApplyRules:         true,
+		FireWebhooks:       false,
+		GroupTitle:         transaction.Attributes.GroupTitle,
+		TransactionUpdates: transactionUpdates,
 	}
-	r.Header.Add("Accept", "application/json")
-
-	return http.DefaultClient.Do(r)
+	return f.updateTransactionAudited(id, updateObj, singleSource(source))
 }
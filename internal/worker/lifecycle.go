@@ -0,0 +1,88 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// activities tracks work in progress, so it can be awaited on shutdown.
+// After stop was called, no new work is started.
+type activities struct {
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// begin registers new work and returns false if it must not be started, because the service is shutting down.
+// Each successful call must be followed by a call to end.
+func (a *activities) begin() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stopped {
+		return false
+	}
+	a.wg.Add(1)
+	return true
+}
+
+func (a *activities) end() {
+	a.wg.Done()
+}
+
+// stop prevents new work and waits until the work in progress ended or the context is done.
+func (a *activities) stop(ctx context.Context) error {
+	a.mu.Lock()
+	a.stopped = true
+	a.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops receiving webhooks and waits for the jobs in progress until the context is done.
+// Jobs which are still running then are canceled. As jobs are only removed from the queue after they succeeded,
+// canceled and pending jobs are processed after the restart.
+func (f *fireflyAPI) Shutdown(ctx context.Context) error {
+	err := f.srv.Shutdown(ctx)
+	close(f.stopping)
+	defer f.cancel()
+	select {
+	case <-f.queueDone:
+		return err
+	case <-ctx.Done():
+		return errors.Join(err, fmt.Errorf("canceled jobs in progress, they are processed again after the restart: %w", ctx.Err()))
+	}
+}
+
+// Shutdown stops receiving webhooks, the scheduler and the Telegram bot, waiting for the work in progress until the context is done.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	var errs []error
+	log.Println(">> Stopping webhooks...")
+	if err := w.fireflyAPI.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("webhooks: %w", err))
+	}
+
+	log.Println(">> Stopping scheduler...")
+	w.scheduler.Stop()
+	if err := w.jobs.stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("scheduled jobs: %w", err))
+	}
+
+	log.Println(">> Stopping Telegram bot...")
+	if err := w.telegramBot.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("telegram bot: %w", err))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// messageMaxAge is the age after which sent notifications are forgotten
+const messageMaxAge = 90 * 24 * time.Hour
+
+// sentMessage is a notification about a transaction. It implements tele.Editable.
+type sentMessage struct {
+	MessageId int       `json:"message_id"`
+	ChatId    int64     `json:"chat_id"`
+	Sent      time.Time `json:"sent"`
+}
+
+// MessageSig implements interface tele.Editable
+func (m sentMessage) MessageSig() (string, int64) {
+	return strconv.Itoa(m.MessageId), m.ChatId
+}
+
+// messageStore remembers the notifications sent for each transaction ID, persisted as JSON. It is safe for concurrent use.
+type messageStore struct {
+	mu       sync.Mutex
+	path     string
+	messages map[string][]sentMessage
+}
+
+// newMessageStore loads the sent notifications from path.
+// If path is empty, they are only kept in memory.
+func newMessageStore(path string) (*messageStore, error) {
+	store := &messageStore{path: path, messages: map[string][]sentMessage{}}
+	if path == "" {
+		return store, nil
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(content, &store.messages); err != nil {
+		return nil, fmt.Errorf("could not parse sent notifications %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// add remembers the message for all given transactions and forgets outdated messages.
+func (s *messageStore) add(msg *tele.Message, transactionIDs ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, messages := range s.messages {
+		var kept []sentMessage
+		for _, m := range messages {
+			if time.Since(m.Sent) < messageMaxAge {
+				kept = append(kept, m)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.messages, id)
+		} else {
+			s.messages[id] = kept
+		}
+	}
+	for _, id := range transactionIDs {
+		s.messages[id] = append(s.messages[id], sentMessage{MessageId: msg.ID, ChatId: msg.Chat.ID, Sent: time.Now()})
+	}
+	return s.save()
+}
+
+// remove forgets and returns the messages of a transaction.
+func (s *messageStore) remove(transactionID string) ([]sentMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	messages, ok := s.messages[transactionID]
+	if !ok {
+		return nil, nil
+	}
+	delete(s.messages, transactionID)
+	return messages, s.save()
+}
+
+// forget forgets a single message of a transaction.
+func (s *messageStore) forget(transactionID string, messageID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var kept []sentMessage
+	for _, m := range s.messages[transactionID] {
+		if m.MessageId != messageID {
+			kept = append(kept, m)
+		}
+	}
+	if len(kept) == 0 {
+		delete(s.messages, transactionID)
+	} else {
+		s.messages[transactionID] = kept
+	}
+	return s.save()
+}
+
+func (s *messageStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(s.messages, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, content, 0600)
+}
@@ -0,0 +1,222 @@
+package worker
+
+import (
+	"encoding/json"
+	"firefly-iii-fix-ing/internal/firefly"
+	"firefly-iii-fix-ing/internal/queue"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// queuePollInterval is the interval in which the queue is checked for due jobs, like retries and replayed jobs
+	queuePollInterval = 5 * time.Second
+	// queueBaseDelay is the delay before the first retry, doubled for each further attempt
+	queueBaseDelay = 30 * time.Second
+	queueMaxDelay  = time.Hour
+)
+
+// jobKey identifies the job for a trigger and transaction, so repeated deliveries replace each other.
+func jobKey(trigger string, transactionID int) string {
+	return fmt.Sprintf("%s/%d", trigger, transactionID)
+}
+
+// enqueue persists the delivery of the webhook and wakes up the queue.
+// Pending jobs of other webhooks for a deleted transaction are dropped, as they can no longer succeed.
+func (f *fireflyAPI) enqueue(wh *webhook, t structs.WhTransactionRead) error {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if wh.attributes.Trigger == "DESTROY_TRANSACTION" {
+		for _, other := range f.webhooks {
+			if other == wh {
+				continue
+			}
+			if err = f.queue.Remove(jobKey(other.attributes.Trigger, t.Id)); err != nil {
+				return err
+			}
+		}
+	}
+	err = f.queue.Push(queue.Job{
+		Key:           jobKey(wh.attributes.Trigger, t.Id),
+		Trigger:       wh.attributes.Trigger,
+		TransactionId: t.Id,
+		Payload:       payload,
+	})
+	if err != nil {
+		return err
+	}
+	select {
+	case f.queueWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// processQueue hands due jobs to the workers, never two jobs of the same transaction at the same time.
+// On shutdown, it stops handing out jobs and waits for the jobs in progress. Blocking.
+func (f *fireflyAPI) processQueue() {
+	defer close(f.queueDone)
+	jobs := make(chan queue.Job)
+	done := make(chan queue.Job)
+	var workers sync.WaitGroup
+	for i := 0; i < f.queueWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for job := range jobs {
+				f.processJob(job)
+				done <- job
+			}
+		}()
+	}
+	stop := func() {
+		close(jobs)
+		go func() {
+			workers.Wait()
+			close(done)
+		}()
+		for range done {
+		}
+	}
+
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+	inFlight := map[string]bool{}
+	// busy contains the transactions with a job in progress, their other jobs wait until it is done
+	busy := map[int]bool{}
+	finish := func(job queue.Job) {
+		delete(inFlight, job.Key)
+		delete(busy, job.TransactionId)
+	}
+	for {
+		due, err := f.queue.Due(time.Now(), inFlight)
+		if err != nil {
+			log.Println("WARNING: could not read webhook queue:", err)
+		}
+		for len(due) > 0 {
+			if busy[due[0].TransactionId] {
+				due = due[1:]
+				continue
+			}
+			select {
+			case jobs <- due[0]:
+				inFlight[due[0].Key] = true
+				busy[due[0].TransactionId] = true
+				due = due[1:]
+			case job := <-done:
+				finish(job)
+			case <-f.stopping:
+				stop()
+				return
+			}
+		}
+		select {
+		case job := <-done:
+			finish(job)
+		case <-f.queueWake:
+		case <-ticker.C:
+		case <-f.stopping:
+			stop()
+			return
+		}
+	}
+}
+
+// processJob runs the handler of the webhook for the job and schedules a retry if it fails.
+// Jobs failing too often are moved to the dead jobs, which can be replayed with the queue command.
+func (f *fireflyAPI) processJob(job queue.Job) {
+	log.Println()
+	log.Printf("### BEGIN %s ###", job.Key)
+	defer log.Println("######### DONE ##########")
+
+	err := f.runJob(&job)
+	if err == nil {
+		if err = f.queue.Done(job); err != nil {
+			log.Println(">> WARNING: could not remove job from queue:", err)
+		}
+		return
+	}
+
+	log.Println(">> WARNING: error processing webhook:", err)
+	if job.Attempts+1 < f.queueMaxAttempts {
+		delay := queueRetryDelay(job.Attempts)
+		log.Printf(">> Retrying in %s (attempt %d of %d)", delay, job.Attempts+2, f.queueMaxAttempts)
+		if err = f.queue.Retry(job, err, time.Now().Add(delay)); err != nil {
+			log.Println(">> WARNING: could not schedule retry:", err)
+		}
+		return
+	}
+
+	log.Printf(">> Giving up after %d attempts", job.Attempts+1)
+	if errBury := f.queue.Bury(job, err); errBury != nil {
+		log.Println(">> WARNING: could not move job to dead jobs:", errBury)
+	}
+	if errNotify := f.notifManager.NotifyError(fmt.Errorf("webhook %s failed %d times, replay it with the queue command: %w", job.Key, job.Attempts+1, err)); errNotify != nil {
+		log.Println(">> WARNING: could not send notification:", errNotify)
+	}
+}
+
+// runJob runs the handler of the webhook for the job.
+// Except for deleted transactions, the transaction is read again on each attempt, as an earlier attempt may have changed it
+// and the payload is outdated then. Jobs of transactions which no longer exist succeed without running the handler.
+func (f *fireflyAPI) runJob(job *queue.Job) error {
+	var t structs.WhTransactionRead
+	if err := json.Unmarshal(job.Payload, &t); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+	for _, wh := range f.webhooks {
+		if wh.attributes.Trigger != job.Trigger {
+			continue
+		}
+		if wh.attributes.Trigger != "DESTROY_TRANSACTION" {
+			current, err := f.getTransaction(job.TransactionId)
+			if firefly.IsNotFound(err) {
+				log.Printf(">> Transaction #%d no longer exists, skipping", job.TransactionId)
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if t, err = transactionToWebhook(current); err != nil {
+				return err
+			}
+		}
+		return wh.handler(t, &jobSteps{job: job})
+	}
+	return fmt.Errorf("unknown trigger %s", job.Trigger)
+}
+
+// jobSteps records the steps of a job which completed, so retries skip side effects like notifications.
+// A nil jobSteps runs all steps.
+type jobSteps struct {
+	job *queue.Job
+}
+
+// once runs the step unless it completed in an earlier attempt of the job.
+func (s *jobSteps) once(step string, fn func() error) error {
+	if s != nil && slices.Contains(s.job.Steps, step) {
+		log.Printf(">> Step '%s' completed in an earlier attempt, skipping", step)
+		return nil
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	if s != nil {
+		s.job.Steps = append(s.job.Steps, step)
+	}
+	return nil
+}
+
+// queueRetryDelay returns the exponential backoff after the given number of previous attempts.
+func queueRetryDelay(attempts int) time.Duration {
+	delay := queueBaseDelay
+	for i := 0; i < attempts && delay < queueMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, queueMaxDelay)
+}
@@ -0,0 +1,202 @@
+package worker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	healthzPath = "/healthz"
+	statusPath  = "/status"
+	// readinessGracePeriod is the time after the start in which failed probes do not degrade the service, for slow proxies
+	readinessGracePeriod = time.Minute
+	// readinessBaseDelay is the delay before the first retry of a failed probe, doubled for each further failure
+	readinessBaseDelay = time.Second
+	readinessMaxDelay  = 5 * time.Minute
+	// readinessInterval is the interval in which the webhook URL is probed again after it was reachable
+	readinessInterval = 5 * time.Minute
+	readinessTimeout  = 10 * time.Second
+)
+
+type readinessState string
+
+const (
+	readinessStarting readinessState = "starting"
+	readinessReady    readinessState = "ready"
+	// readinessDegraded means Firefly probably cannot deliver webhooks, the service keeps running to recover
+	readinessDegraded readinessState = "degraded"
+)
+
+// readiness tracks whether the server is reachable at the public webhook URL.
+type readiness struct {
+	// instance identifies this process in responses of the healthz endpoint,
+	// so probes reaching another server at the URL are not mistaken as success
+	instance  string
+	url       string
+	started   time.Time
+	mu        sync.Mutex
+	state     readinessState
+	since     time.Time
+	lastProbe time.Time
+	lastError string
+	failures  int
+}
+
+func newReadiness(webhookURL string) *readiness {
+	instance := make([]byte, 8)
+	_, _ = rand.Read(instance)
+	now := time.Now()
+	return &readiness{
+		instance: hex.EncodeToString(instance),
+		url:      webhookURL + healthzPath,
+		started:  now,
+		state:    readinessStarting,
+		since:    now,
+	}
+}
+
+// record updates the state with the result of a probe and returns the previous state if it changed.
+func (r *readiness) record(err error, now time.Time) (previous readinessState, changed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	previous = r.state
+	r.lastProbe = now
+	next := readinessReady
+	if err != nil {
+		r.failures++
+		r.lastError = err.Error()
+		next = readinessDegraded
+		if r.state == readinessStarting && now.Sub(r.started) < readinessGracePeriod {
+			next = readinessStarting
+		}
+	} else {
+		r.failures = 0
+		r.lastError = ""
+	}
+	if next == r.state {
+		return previous, false
+	}
+	r.state = next
+	r.since = now
+	return previous, true
+}
+
+// delay returns the time until the next probe.
+func (r *readiness) delay() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failures == 0 {
+		return readinessInterval
+	}
+	delay := readinessBaseDelay << min(r.failures-1, 20)
+	return min(delay, readinessMaxDelay)
+}
+
+// handleHealthz answers probes, also those of container runtimes, with the instance ID.
+func (r *readiness) handleHealthz(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = io.WriteString(w, r.instance)
+}
+
+// handleStatus reports the readiness as JSON, with status 503 unless ready.
+func (r *readiness) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	r.mu.Lock()
+	status := struct {
+		State     readinessState `json:"state"`
+		Since     time.Time      `json:"since"`
+		URL       string         `json:"url"`
+		LastProbe *time.Time     `json:"last_probe,omitempty"`
+		LastError string         `json:"last_error,omitempty"`
+		Failures  int            `json:"failures"`
+	}{
+		State:     r.state,
+		Since:     r.since,
+		URL:       r.url,
+		LastError: r.lastError,
+		Failures:  r.failures,
+	}
+	if !r.lastProbe.IsZero() {
+		lastProbe := r.lastProbe
+		status.LastProbe = &lastProbe
+	}
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if status.State != readinessReady {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Println("WARNING: could not write status:", err)
+	}
+}
+
+// probe requests the healthz endpoint at the public webhook URL and checks the response is from this instance.
+func (r *readiness) probe(client *http.Client) error {
+	resp, err := client.Get(r.url)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := resp.Body.Close(); errClose != nil {
+			log.Printf("WARNING: error closing response body: %v", errClose)
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("got status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(body)) != r.instance {
+		return errors.New("response is not from this instance, is the URL forwarded to another server?")
+	}
+	return nil
+}
+
+// checkReadiness probes the public webhook URL until shutdown, retrying failed probes with backoff.
+// Changes of the state are logged and notified. Blocking.
+func (f *fireflyAPI) checkReadiness() {
+	client := &http.Client{Timeout: readinessTimeout}
+	// give the server a little time to start before the first probe
+	delay := readinessBaseDelay
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-f.stopping:
+			timer.Stop()
+			return
+		}
+
+		err := f.readiness.probe(client)
+		previous, changed := f.readiness.record(err, time.Now())
+		switch {
+		case err != nil && !changed:
+			log.Printf("WARNING: webhook URL %s not reachable: %v", f.readiness.url, err)
+		case err != nil:
+			log.Printf("WARNING: webhook URL %s not reachable, Firefly cannot deliver webhooks: %v", f.readiness.url, err)
+			if errNotify := f.notifManager.NotifyReadiness(false, f.webhookURL, err); errNotify != nil {
+				log.Println("WARNING: could not send notification:", errNotify)
+			}
+		case changed:
+			log.Println("Connection to", f.webhookURL, "validated")
+			log.Println("Ready to accept connections!")
+			if previous == readinessDegraded {
+				if errNotify := f.notifManager.NotifyReadiness(true, f.webhookURL, nil); errNotify != nil {
+					log.Println("WARNING: could not send notification:", errNotify)
+				}
+			}
+		}
+		delay = f.readiness.delay()
+	}
+}
@@ -0,0 +1,259 @@
+package worker
+
+import (
+	"errors"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// recurringLookback is how far back previous payments are searched
+	recurringLookback = 400 * 24 * time.Hour
+	// recurringAmountTolerance is the relative amount difference up to which payments are considered similar
+	recurringAmountTolerance = 0.1
+	// recurringHistoryTTL is how long the withdrawals of an account are reused for detecting recurring payments
+	recurringHistoryTTL = time.Hour
+)
+
+// billFrequency describes a cadence of recurring payments.
+type billFrequency struct {
+	repeatFreq string
+	// minDays and maxDays limit the days between two payments
+	minDays int
+	maxDays int
+	// occurrences is the number of payments including the current one needed to detect the cadence
+	occurrences int
+}
+
+var billFrequencies = []billFrequency{
+	{"monthly", 26, 35, 3},
+	{"quarterly", 85, 97, 3},
+	{"yearly", 355, 375, 2},
+}
+
+// recurringPayment is a detected pattern of recurring payments.
+type recurringPayment struct {
+	name       string
+	repeatFreq string
+	amountMin  float64
+	amountMax  float64
+	date       time.Time
+}
+
+// findBill returns the active bill matching the counterparty and amount of the withdrawal, or nil.
+// The name of the bill has to match whole words of the counterparty, so short names like O2 do not match within other words.
+func findBill(bills []structs.BillRead, counterparty string, amount float64) *structs.BillRead {
+	normalizedCounterparty := " " + normalizeCounterparty(counterparty) + " "
+	for i, bill := range bills {
+		if !bill.Attributes.Active {
+			continue
+		}
+		name := normalizeCounterparty(bill.Attributes.Name)
+		if name == "" || !strings.Contains(normalizedCounterparty, " "+name+" ") {
+			continue
+		}
+		amountMin, errMin := strconv.ParseFloat(bill.Attributes.AmountMin, 64)
+		amountMax, errMax := strconv.ParseFloat(bill.Attributes.AmountMax, 64)
+		if errMin == nil && errMax == nil && amount >= amountMin && amount <= amountMax {
+			return &bills[i]
+		}
+	}
+	return nil
+}
+
+// checkRecurring links a withdrawal to a matching bill or, if it belongs to recurring payments without bill,
+// offers to create one.
+func (f *fireflyAPI) checkRecurring(t *structs.TransactionRead) error {
+	if len(t.Attributes.Transactions) != 1 {
+		return nil
+	}
+	split := t.Attributes.Transactions[0]
+	if split.Type != "withdrawal" || split.BillId != "" {
+		return nil
+	}
+	amount, err := strconv.ParseFloat(split.Amount, 64)
+	if err != nil {
+		return err
+	}
+	amount = math.Abs(amount)
+
+	bills, err := f.getBills()
+	if err != nil {
+		return err
+	}
+	if bill := findBill(bills, split.DestinationName, amount); bill != nil {
+		log.Printf(">> Linking transaction to bill '%s'...", bill.Attributes.Name)
+		return f.setTransactionBill(t, bill.Attributes.Name)
+	}
+
+	payment, err := f.detectRecurringPayment(t)
+	if err != nil || payment == nil {
+		return err
+	}
+	log.Printf(">> Detected %s payment to '%s' without bill, sending notification...", payment.repeatFreq, payment.name)
+	return f.notifManager.NotifyRecurringPayment(t, payment.repeatFreq, f.fireflyBaseURL)
+}
+
+// detectRecurringPayment checks whether previous withdrawals to the same counterparty with a similar amount
+// follow one of the billFrequencies. Returns nil if not.
+func (f *fireflyAPI) detectRecurringPayment(t *structs.TransactionRead) (*recurringPayment, error) {
+	split := t.Attributes.Transactions[0]
+	amount, err := strconv.ParseFloat(split.Amount, 64)
+	if err != nil {
+		return nil, err
+	}
+	amount = math.Abs(amount)
+	date, err := time.Parse(time.RFC3339, split.Date)
+	if err != nil {
+		return nil, err
+	}
+	history, err := f.recurringHistory.get(split.SourceId, date.Add(-recurringLookback), func(start time.Time, end time.Time) ([]structs.TransactionRead, error) {
+		return f.getAccountTransactions(split.SourceId, start, end, "withdrawal")
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	counterparty := normalizeCounterparty(split.DestinationName)
+	payment := &recurringPayment{name: split.DestinationName, amountMin: amount, amountMax: amount, date: date}
+	dates := []time.Time{date}
+	for _, candidate := range history {
+		if candidate.Id == t.Id || len(candidate.Attributes.Transactions) != 1 {
+			continue
+		}
+		candidateSplit := candidate.Attributes.Transactions[0]
+		if normalizeCounterparty(candidateSplit.DestinationName) != counterparty {
+			continue
+		}
+		candidateAmount, err := strconv.ParseFloat(candidateSplit.Amount, 64)
+		if err != nil || math.Abs(math.Abs(candidateAmount)-amount) > amount*recurringAmountTolerance {
+			continue
+		}
+		candidateDate, err := time.Parse(time.RFC3339, candidateSplit.Date)
+		if err != nil || !candidateDate.Before(date) || candidateDate.Before(date.Add(-recurringLookback)) {
+			continue
+		}
+		dates = append(dates, candidateDate)
+		payment.amountMin = math.Min(payment.amountMin, math.Abs(candidateAmount))
+		payment.amountMax = math.Max(payment.amountMax, math.Abs(candidateAmount))
+	}
+	// newest first
+	sort.Slice(dates, func(i, j int) bool { return dates[i].After(dates[j]) })
+
+	for _, frequency := range billFrequencies {
+		if len(dates) < frequency.occurrences {
+			continue
+		}
+		matches := true
+		for i := 1; i < frequency.occurrences; i++ {
+			days := int(math.Round(dates[i-1].Sub(dates[i]).Hours() / 24))
+			matches = matches && days >= frequency.minDays && days <= frequency.maxDays
+		}
+		if matches {
+			payment.repeatFreq = frequency.repeatFreq
+			return payment, nil
+		}
+	}
+	return nil, nil
+}
+
+// accountHistory caches the withdrawals of accounts, as each withdrawal would read a year of them otherwise.
+// Withdrawals created since they were read are missing, which is fine for payments recurring at most monthly.
+type accountHistory struct {
+	mu       sync.Mutex
+	accounts map[string]accountHistoryEntry
+}
+
+type accountHistoryEntry struct {
+	read         time.Time
+	start        time.Time
+	transactions []structs.TransactionRead
+}
+
+// get returns the withdrawals of the account since start until now, reading them with fetch unless they were read
+// within recurringHistoryTTL.
+func (h *accountHistory) get(accountID string, start time.Time, fetch func(start time.Time, end time.Time) ([]structs.TransactionRead, error)) ([]structs.TransactionRead, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if entry, ok := h.accounts[accountID]; ok && now.Sub(entry.read) < recurringHistoryTTL && !entry.start.After(start) {
+		return entry.transactions, nil
+	}
+	transactions, err := fetch(start, now)
+	if err != nil {
+		return nil, err
+	}
+	if h.accounts == nil {
+		h.accounts = map[string]accountHistoryEntry{}
+	}
+	h.accounts[accountID] = accountHistoryEntry{read: now, start: start, transactions: transactions}
+	return transactions, nil
+}
+
+// CreateBillForTransaction implements interface transactionUpdater.
+// The bill covers the amounts of the detected recurring payments and the transaction is linked to it.
+func (f *fireflyAPI) CreateBillForTransaction(id int) (*structs.BillRead, error) {
+	transaction, err := f.getTransaction(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(transaction.Attributes.Transactions) != 1 {
+		return nil, errors.New("only transactions with a single split are supported")
+	}
+	payment, err := f.detectRecurringPayment(transaction)
+	if err != nil {
+		return nil, err
+	} else if payment == nil {
+		return nil, errors.New("no recurring payments found")
+	}
+	bill, err := f.createBill(structs.BillAttributes{
+		Name:       payment.name,
+		AmountMin:  fmt.Sprintf("%.2f", payment.amountMin),
+		AmountMax:  fmt.Sprintf("%.2f", payment.amountMax),
+		Date:       payment.date.Format(time.DateOnly),
+		RepeatFreq: payment.repeatFreq,
+		Active:     true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = f.setTransactionBill(transaction, bill.Attributes.Name); err != nil {
+		return nil, fmt.Errorf("bill created, but could not link transaction: %w", err)
+	}
+	return bill, nil
+}
+
+// getBills returns all bills, reading all pages.
+func (f *fireflyAPI) getBills() ([]structs.BillRead, error) {
+	return f.client.Bills().All(f.ctx)
+}
+
+func (f *fireflyAPI) createBill(attributes structs.BillAttributes) (*structs.BillRead, error) {
+	return f.client.CreateBill(f.ctx, attributes)
+}
+
+func (f *fireflyAPI) setTransactionBill(transaction *structs.TransactionRead, billName string) error {
+	id, err := strconv.Atoi(transaction.Id)
+	if err != nil {
+		return err
+	}
+	journalID, err := strconv.Atoi(transaction.Attributes.Transactions[0].JournalId)
+	if err != nil {
+		return err
+	}
+	updateObj := &structs.TransactionUpdate{
+		ApplyRules:         false,
+		FireWebhooks:       false,
+		GroupTitle:         transaction.Attributes.GroupTitle,
+		TransactionUpdates: []structs.TransactionSplitUpdate{{JournalId: journalID, BillName: billName}},
+	}
+	_, err = f.updateTransactionAudited(id, updateObj, singleSource(auditSourceRecurring))
+	return err
+}
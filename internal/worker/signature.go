@@ -0,0 +1,64 @@
+package worker
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	// signatureHeader is the header in which Firefly sends the signature of webhook deliveries
+	signatureHeader = "Signature"
+	// signatureMaxAge is the maximum difference between the signature timestamp and now, to reject replayed deliveries
+	signatureMaxAge = 5 * time.Minute
+)
+
+var (
+	// errSignatureMalformed is returned for missing or unparsable signature headers
+	errSignatureMalformed = errors.New("malformed signature header")
+	// errSignatureInvalid is returned if the signature does not match or is outside the accepted time window
+	errSignatureInvalid = errors.New("invalid signature")
+)
+
+// verifySignature checks the signature header of a webhook delivery, which Firefly formats as t=<unix timestamp>,v1=<signature>.
+// The signature is the hex encoded HMAC-SHA3-256 of "<timestamp>.<body>", keyed with the secret of the webhook.
+func verifySignature(header string, body []byte, secret string, now time.Time) error {
+	var timestamp, signature string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signature = value
+		}
+	}
+	if timestamp == "" || signature == "" {
+		return errSignatureMalformed
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errSignatureMalformed
+	}
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return errSignatureMalformed
+	}
+
+	if age := now.Sub(time.Unix(unix, 0)); age > signatureMaxAge || age < -signatureMaxAge {
+		return fmt.Errorf("%w: timestamp %s outside of accepted window", errSignatureInvalid, time.Unix(unix, 0).Format(time.DateTime))
+	}
+	mac := hmac.New(sha3.New256, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return errSignatureInvalid
+	}
+	return nil
+}
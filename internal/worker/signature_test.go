@@ -0,0 +1,104 @@
+package worker
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+func sign(secret string, timestamp int64, body string) string {
+	mac := hmac.New(sha3.New256, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "." + body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifySignature(t *testing.T) {
+	now := time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)
+	timestamp := now.Unix()
+	body := `{"uuid":"abc"}`
+	valid := "t=" + strconv.FormatInt(timestamp, 10) + ",v1=" + sign("secret", timestamp, body)
+	type args struct {
+		header string
+		body   string
+		secret string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr error
+	}{
+		{
+			"valid signature",
+			args{header: valid, body: body, secret: "secret"},
+			nil,
+		},
+		{
+			"valid signature with spaces",
+			args{header: "t=" + strconv.FormatInt(timestamp, 10) + ", v1=" + sign("secret", timestamp, body), body: body, secret: "secret"},
+			nil,
+		},
+		{
+			"tampered body",
+			args{header: valid, body: `{"uuid":"abd"}`, secret: "secret"},
+			errSignatureInvalid,
+		},
+		{
+			"wrong secret",
+			args{header: valid, body: body, secret: "other"},
+			errSignatureInvalid,
+		},
+		{
+			"timestamp too old",
+			args{header: "t=" + strconv.FormatInt(timestamp-301, 10) + ",v1=" + sign("secret", timestamp-301, body), body: body, secret: "secret"},
+			errSignatureInvalid,
+		},
+		{
+			"timestamp in the future",
+			args{header: "t=" + strconv.FormatInt(timestamp+301, 10) + ",v1=" + sign("secret", timestamp+301, body), body: body, secret: "secret"},
+			errSignatureInvalid,
+		},
+		{
+			"timestamp at edge of window",
+			args{header: "t=" + strconv.FormatInt(timestamp-300, 10) + ",v1=" + sign("secret", timestamp-300, body), body: body, secret: "secret"},
+			nil,
+		},
+		{
+			"missing timestamp",
+			args{header: "v1=" + sign("secret", timestamp, body), body: body, secret: "secret"},
+			errSignatureMalformed,
+		},
+		{
+			"missing signature",
+			args{header: "t=" + strconv.FormatInt(timestamp, 10), body: body, secret: "secret"},
+			errSignatureMalformed,
+		},
+		{
+			"non-numeric timestamp",
+			args{header: "t=now,v1=" + sign("secret", timestamp, body), body: body, secret: "secret"},
+			errSignatureMalformed,
+		},
+		{
+			"non-hex signature",
+			args{header: "t=" + strconv.FormatInt(timestamp, 10) + ",v1=xyz", body: body, secret: "secret"},
+			errSignatureMalformed,
+		},
+		{
+			"empty header",
+			args{header: "", body: body, secret: "secret"},
+			errSignatureMalformed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifySignature(tt.args.header, []byte(tt.args.body), tt.args.secret, now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("verifySignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -2,6 +2,10 @@ package worker
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"firefly-iii-fix-ing/internal/classifier"
+	"firefly-iii-fix-ing/internal/modules"
 	"firefly-iii-fix-ing/internal/structs"
 	"fmt"
 	"html/template"
@@ -19,10 +23,21 @@ type TelegramBot struct {
 	targetChat         *tele.Chat
 	bot                *tele.Bot
 	transactionUpdater transactionUpdater
+	aliases            *modules.AliasStore
+	messages           *messageStore
+	// handlers tracks the handlers in progress, to wait for them on shutdown
+	handlers activities
 }
 
 type transactionUpdater interface {
+	GetTransaction(id int) (*structs.TransactionRead, error)
 	SetTransactionCategory(id int, categoryName string) (*structs.TransactionRead, error)
+	SetTransactionCounterparty(t *structs.TransactionRead, name string) (*structs.TransactionRead, error)
+	MergeTransfer(id int, counterpartID int) (*structs.TransactionRead, error)
+	DeleteTransaction(id int) error
+	CreateBillForTransaction(id int) (*structs.BillRead, error)
+	RevertTransaction(id int) (*structs.TransactionRead, error)
+	CanRevert(id int) bool
 	FireflyBaseURL() string
 }
 
@@ -48,7 +63,14 @@ func NewBot(token string, chatID int64) (*TelegramBot, error) {
 		bot:        bot,
 	}
 
+	// must be registered before the handlers to apply to them
+	bot.Use(telegramBot.trackHandler)
 	bot.Handle("/start", telegramBot.handleStart)
+	bot.Handle("/alias", telegramBot.handleAlias)
+	bot.Handle(&tele.Btn{Unique: buttonUniqueMergeTransfer}, telegramBot.handleMergeTransfer)
+	bot.Handle(&tele.Btn{Unique: buttonUniqueDeleteDuplicate}, telegramBot.handleDeleteDuplicate)
+	bot.Handle(&tele.Btn{Unique: buttonUniqueCreateBill}, telegramBot.handleCreateBill)
+	bot.Handle(&tele.Btn{Unique: buttonUniqueRevert}, telegramBot.handleRevert)
 	bot.Handle(tele.OnCallback, telegramBot.handleInlineQueries)
 
 	return telegramBot, nil
@@ -60,11 +82,70 @@ func (b *TelegramBot) Listen() {
 	b.bot.Start()
 }
 
+// Stop stops receiving updates and waits for the handlers in progress until the context is done.
+func (b *TelegramBot) Stop(ctx context.Context) error {
+	b.bot.Stop()
+	return b.handlers.stop(ctx)
+}
+
+// trackHandler is a middleware registering the handler in progress, rejecting updates during shutdown.
+func (b *TelegramBot) trackHandler(next tele.HandlerFunc) tele.HandlerFunc {
+	return func(c tele.Context) error {
+		if !b.handlers.begin() {
+			const text = "Der Bot wird gerade neu gestartet, bitte gleich erneut versuchen."
+			if c.Callback() != nil {
+				return c.Respond(&tele.CallbackResponse{Text: text})
+			}
+			return c.Send(text)
+		}
+		defer b.handlers.end()
+		return next(c)
+	}
+}
+
 func (b *TelegramBot) handleStart(c tele.Context) error {
 	return c.Send(fmt.Sprintf("Hallo %s!\nDieser Bot ist eingerichtet für Nutzer "+
 		"<a href=\"tg://user?id=%d\">%d</a>.", c.Chat().FirstName, b.targetChat.ID, b.targetChat.ID), tele.ModeHTML)
 }
 
+// handleAlias adds the counterparty of a transaction as alias for a canonical name and renames the counterparty.
+// Usage: /alias <transaction ID> <name>
+func (b *TelegramBot) handleAlias(c tele.Context) error {
+	if c.Chat().ID != b.targetChat.ID {
+		return c.Send("Dieser Befehl ist nur für den eingerichteten Nutzer verfügbar.")
+	}
+	args := c.Args()
+	if len(args) < 2 {
+		return c.Send("Verwendung: /alias &lt;Transaktions-ID&gt; &lt;Name&gt;", tele.ModeHTML)
+	}
+	transactionID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return c.Send(fmt.Sprintf("Transaktions-ID %s ungültig!", args[0]))
+	}
+	name := strings.Join(args[1:], " ")
+
+	log.Printf("Requested alias '%s' for transaction #%d", name, transactionID)
+	transaction, err := b.transactionUpdater.GetTransaction(transactionID)
+	if err != nil {
+		return c.Send("Transaktion konnte nicht abgerufen werden: " + err.Error())
+	} else if len(transaction.Attributes.Transactions) == 0 {
+		return c.Send("Transaktion enthält keine Buchungen.")
+	}
+	split := transaction.Attributes.Transactions[0]
+	counterparty := split.DestinationName
+	if split.Type == "deposit" {
+		counterparty = split.SourceName
+	}
+
+	if err = b.aliases.Add(modules.PayeeAlias{Type: modules.AliasExact, Pattern: counterparty, Name: name}); err != nil {
+		return c.Send("Alias konnte nicht gespeichert werden: " + err.Error())
+	}
+	if _, err = b.transactionUpdater.SetTransactionCounterparty(transaction, name); err != nil {
+		return c.Send("Alias gespeichert, Transaktion konnte aber nicht aktualisiert werden: " + err.Error())
+	}
+	return c.Send(fmt.Sprintf("„%s“ wird ab jetzt zu „%s“.", formatStr(counterparty, maxLenAccountName), formatStr(name, maxLenAccountName)), tele.ModeHTML)
+}
+
 func (b *TelegramBot) handleInlineQueries(c tele.Context) error {
 	log.Println("##### BEGIN CALLBACK ####")
 	defer log.Println("###### END CALLBACK #####")
@@ -113,6 +194,166 @@ func (b *TelegramBot) handleInlineQueries(c tele.Context) error {
 	})
 }
 
+// handleMergeTransfer merges two transactions into a transfer after the button in a transfer notification was pressed.
+func (b *TelegramBot) handleMergeTransfer(c tele.Context) error {
+	log.Println("##### BEGIN CALLBACK ####")
+	defer log.Println("###### END CALLBACK #####")
+	var responseMsg string
+	var editBody string
+
+	args := c.Args()
+	if len(args) != 2 {
+		responseMsg = "Ungültige Anfrage!"
+	} else if transactionID, err := strconv.Atoi(args[0]); err != nil {
+		responseMsg = fmt.Sprintf("Transaktions-ID %s ungültig!", args[0])
+	} else if counterpartID, err := strconv.Atoi(args[1]); err != nil {
+		responseMsg = fmt.Sprintf("Transaktions-ID %s ungültig!", args[1])
+	} else {
+		log.Printf("Requested merge of #%d and #%d into transfer", transactionID, counterpartID)
+		if merged, err := b.transactionUpdater.MergeTransfer(transactionID, counterpartID); errors.Is(err, errAlreadyMerged) {
+			responseMsg = "Bereits zusammengeführt oder gelöscht"
+		} else if err != nil {
+			responseMsg = "Zusammenführen fehlgeschlagen: " + err.Error()
+		} else {
+			responseMsg = "Zu Umbuchung zusammengeführt"
+			// the counterpart is deleted, this message stays valid for the merged transaction
+			b.forgetMessage(args[1], c.Message())
+			editBody, _ = b.transactionToMessageBody(merged, b.transactionUpdater.FireflyBaseURL())
+		}
+	}
+
+	var err error
+	if editBody != "" {
+		err = c.Edit(editBody, &tele.ReplyMarkup{}, tele.ModeHTML)
+	} else {
+		err = c.Edit(&tele.ReplyMarkup{})
+	}
+	if err != nil {
+		log.Println("WARNING: could not delete inline buttons:", err)
+	}
+	log.Printf(">> Sending response message: '%s'", responseMsg)
+	return c.Respond(&tele.CallbackResponse{
+		Text:      responseMsg,
+		ShowAlert: false,
+	})
+}
+
+// handleDeleteDuplicate deletes a transaction after the button in a duplicate notification was pressed.
+func (b *TelegramBot) handleDeleteDuplicate(c tele.Context) error {
+	log.Println("##### BEGIN CALLBACK ####")
+	defer log.Println("###### END CALLBACK #####")
+	var responseMsg string
+
+	args := c.Args()
+	if transactionID, err := strconv.Atoi(args[0]); err != nil {
+		responseMsg = fmt.Sprintf("Transaktions-ID %s ungültig!", args[0])
+	} else {
+		log.Printf("Requested deletion of duplicate #%d", transactionID)
+		if err = b.transactionUpdater.DeleteTransaction(transactionID); err != nil {
+			responseMsg = "Löschen fehlgeschlagen: " + err.Error()
+		} else {
+			responseMsg = fmt.Sprintf("Transaktion #%d gelöscht", transactionID)
+		}
+	}
+
+	if err := c.Edit(&tele.ReplyMarkup{}); err != nil {
+		log.Println("WARNING: could not delete inline buttons:", err)
+	}
+	log.Printf(">> Sending response message: '%s'", responseMsg)
+	return c.Respond(&tele.CallbackResponse{
+		Text:      responseMsg,
+		ShowAlert: false,
+	})
+}
+
+// handleCreateBill creates a bill for a recurring payment after the button in a recurring payment notification was pressed.
+func (b *TelegramBot) handleCreateBill(c tele.Context) error {
+	log.Println("##### BEGIN CALLBACK ####")
+	defer log.Println("###### END CALLBACK #####")
+	var responseMsg string
+
+	args := c.Args()
+	if transactionID, err := strconv.Atoi(args[0]); err != nil {
+		responseMsg = fmt.Sprintf("Transaktions-ID %s ungültig!", args[0])
+	} else {
+		log.Printf("Requested bill for transaction #%d", transactionID)
+		if bill, err := b.transactionUpdater.CreateBillForTransaction(transactionID); err != nil {
+			responseMsg = "Abonnement konnte nicht angelegt werden: " + err.Error()
+		} else {
+			responseMsg = fmt.Sprintf("Abonnement „%s“ angelegt", bill.Attributes.Name)
+		}
+	}
+
+	if err := c.Edit(&tele.ReplyMarkup{}); err != nil {
+		log.Println("WARNING: could not delete inline buttons:", err)
+	}
+	log.Printf(">> Sending response message: '%s'", responseMsg)
+	return c.Respond(&tele.CallbackResponse{
+		Text:      responseMsg,
+		ShowAlert: false,
+	})
+}
+
+// handleRevert reverts the recorded changes of a transaction after the button in a notification was pressed.
+// Only the revert button is removed, so the remaining buttons can still be used.
+func (b *TelegramBot) handleRevert(c tele.Context) error {
+	log.Println("##### BEGIN CALLBACK ####")
+	defer log.Println("###### END CALLBACK #####")
+	var responseMsg string
+
+	args := c.Args()
+	if transactionID, err := strconv.Atoi(args[0]); err != nil {
+		responseMsg = fmt.Sprintf("Transaktions-ID %s ungültig!", args[0])
+	} else {
+		log.Printf("Requested revert of transaction #%d", transactionID)
+		if _, err = b.transactionUpdater.RevertTransaction(transactionID); err != nil {
+			responseMsg = "Rückgängig machen fehlgeschlagen: " + err.Error()
+		} else {
+			responseMsg = fmt.Sprintf("Änderungen an Transaktion #%d rückgängig gemacht", transactionID)
+		}
+	}
+
+	if err := c.Edit(withoutButton(c.Message().ReplyMarkup, buttonUniqueRevert)); err != nil {
+		log.Println("WARNING: could not delete inline button:", err)
+	}
+	log.Printf(">> Sending response message: '%s'", responseMsg)
+	return c.Respond(&tele.CallbackResponse{
+		Text:      responseMsg,
+		ShowAlert: false,
+	})
+}
+
+// withoutButton returns the inline keyboard without the buttons with the given unique, dropping empty rows.
+func withoutButton(markup *tele.ReplyMarkup, unique string) *tele.ReplyMarkup {
+	result := &tele.ReplyMarkup{}
+	if markup == nil {
+		return result
+	}
+	for _, row := range markup.InlineKeyboard {
+		var buttons []tele.InlineButton
+		for _, button := range row {
+			if button.Unique != unique && !strings.HasPrefix(button.Data, "\f"+unique+"|") {
+				buttons = append(buttons, button)
+			}
+		}
+		if len(buttons) > 0 {
+			result.InlineKeyboard = append(result.InlineKeyboard, buttons)
+		}
+	}
+	return result
+}
+
+// addRevertButton adds a row with a button to revert the recorded changes of the transaction, if there are any.
+func (b *TelegramBot) addRevertButton(menu *tele.ReplyMarkup, t *structs.TransactionRead) {
+	id, err := strconv.Atoi(t.Id)
+	if err != nil || !b.transactionUpdater.CanRevert(id) {
+		return
+	}
+	menu.InlineKeyboard = append(menu.InlineKeyboard, []tele.InlineButton{
+		*menu.Data("↩️ Rückgängig", buttonUniqueRevert, t.Id).Inline(),
+	})
+}
+
 type notificationParams struct {
 	TransactionID   string
 	TransactionHref string
@@ -153,6 +394,24 @@ var notificationTemplate = template.Must(template.New("telegramNotification").Pa
 	💶 <u><b>{{.AmountStr}}</b></u>
 {{end}}</tg-spoiler>`))
 
+// questionNotificationParams describe a notification asking about one or more related transactions.
+type questionNotificationParams struct {
+	Title        string
+	Question     string
+	Transactions []*notificationParams
+}
+
+var questionTemplate = template.Must(template.New("telegramQuestion").Parse(`
+<b>{{.Title}}</b>
+{{range .Transactions}}
+<a href="{{.TransactionHref}}">Transaktion #{{.TransactionID}}</a>{{range .SubTransactions}}
+	✏️ {{.Description}}
+	📆 {{.DateStr}}
+	⚖️ {{.SourceName}} ➜ {{.DestinationName}}
+	💶 <u><b>{{.AmountStr}}</b></u>
+{{end}}{{end}}
+{{.Question}}`))
+
 const maxLenDescription = 50
 const maxLenAccountName = 25
 
@@ -173,8 +432,26 @@ var months = []string{
 
 const buttonsPerRow = 3
 const buttonDataDone = "fertig"
+const buttonUniqueMergeTransfer = "transfer"
+const buttonUniqueDeleteDuplicate = "duplicate"
+const buttonUniqueCreateBill = "bill"
+const buttonUniqueRevert = "revert"
+
+var repeatFrequencies = map[string]string{
+	"monthly":   "monatlich",
+	"quarterly": "vierteljährlich",
+	"yearly":    "jährlich",
+}
 
 func (b *TelegramBot) transactionToMessageBody(t *structs.TransactionRead, fireflyBaseURL string) (string, error) {
+	body := bytes.NewBufferString(``)
+	if err := notificationTemplate.Execute(body, transactionToNotificationParams(t, fireflyBaseURL)); err != nil {
+		return "", err
+	}
+	return body.String(), nil
+}
+
+func transactionToNotificationParams(t *structs.TransactionRead, fireflyBaseURL string) *notificationParams {
 	// assemble transactions
 	transactions := make([]transactionNotification, len(t.Attributes.Transactions))
 	for i, transaction := range t.Attributes.Transactions {
@@ -188,17 +465,12 @@ func (b *TelegramBot) transactionToMessageBody(t *structs.TransactionRead, firef
 			transaction.Description,
 		)
 	}
-	params := newNotificationParams(t.Id, fireflyBaseURL, transactions)
-
-	body := bytes.NewBufferString(``)
-	if err := notificationTemplate.Execute(body, params); err != nil {
-		return "", err
-	}
-	return body.String(), nil
+	return newNotificationParams(t.Id, fireflyBaseURL, transactions)
 }
 
 // NotifyNewTransaction implements interface transactionNotifier
-func (b *TelegramBot) NotifyNewTransaction(t *structs.TransactionRead, fireflyBaseURL string, categories []structs.CategoryRead) error {
+// Suggested categories are shown as the first row.
+func (b *TelegramBot) NotifyNewTransaction(t *structs.TransactionRead, fireflyBaseURL string, categories []structs.CategoryRead, suggestions []classifier.Prediction) error {
 	if len(t.Attributes.Transactions) == 0 {
 		return nil
 	}
@@ -225,20 +497,144 @@ func (b *TelegramBot) NotifyNewTransaction(t *structs.TransactionRead, fireflyBa
 	// insert "Done"-button
 	rows[len(rows)-1] = menu.Row(menu.Data("👍 Passt", t.Id+buttonDataDone, buttonDataDone))
 
+	// insert suggestions
+	if len(suggestions) > 0 {
+		suggestionButtons := make([]tele.Btn, len(suggestions))
+		for i, suggestion := range suggestions {
+			label := fmt.Sprintf("✨ %s (%.0f%%)", suggestion.Category, suggestion.Probability*100)
+			suggestionButtons[i] = menu.Data(label, fmt.Sprintf("%ss%d", t.Id, i), t.Id, suggestion.Category)
+		}
+		rows = append([]tele.Row{menu.Row(suggestionButtons...)}, rows...)
+	}
+
 	menu.Inline(rows...)
+	b.addRevertButton(&menu, t)
 
 	notificationBody, err := b.transactionToMessageBody(t, fireflyBaseURL)
 	if err != nil {
 		return err
 	}
 
-	_, err = b.bot.Send(
+	msg, err := b.bot.Send(
 		b.targetChat,
 		notificationBody,
 		&menu,
 		tele.ModeHTML,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	b.rememberMessage(msg, t.Id)
+	return nil
+}
+
+// NotifyTransferCandidate implements interface transactionNotifier
+func (b *TelegramBot) NotifyTransferCandidate(t *structs.TransactionRead, counterpart *structs.TransactionRead, fireflyBaseURL string) error {
+	menu := tele.ReplyMarkup{}
+	menu.Inline(menu.Row(
+		menu.Data("🔁 Zusammenführen", buttonUniqueMergeTransfer, t.Id, counterpart.Id),
+		menu.Data("✖️ Ignorieren", t.Id+buttonDataDone, buttonDataDone),
+	))
+	b.addRevertButton(&menu, t)
+	return b.sendQuestion(&questionNotificationParams{
+		Title:    "🔁 Mögliche Umbuchung 🔁",
+		Question: "Sollen beide Transaktionen zu einer Umbuchung zusammengeführt werden?",
+		Transactions: []*notificationParams{
+			transactionToNotificationParams(t, fireflyBaseURL),
+			transactionToNotificationParams(counterpart, fireflyBaseURL),
+		},
+	}, &menu)
+}
+
+// NotifyDuplicate implements interface transactionNotifier
+func (b *TelegramBot) NotifyDuplicate(t *structs.TransactionRead, original *structs.TransactionRead, fireflyBaseURL string) error {
+	menu := tele.ReplyMarkup{}
+	menu.Inline(menu.Row(
+		menu.Data("🗑️ Duplikat löschen", buttonUniqueDeleteDuplicate, t.Id),
+		menu.Data("✖️ Behalten", t.Id+buttonDataDone, buttonDataDone),
+	))
+	b.addRevertButton(&menu, t)
+	return b.sendQuestion(&questionNotificationParams{
+		Title:    "👯 Mögliches Duplikat 👯",
+		Question: fmt.Sprintf("Soll Transaktion #%s als Duplikat gelöscht werden?", t.Id),
+		Transactions: []*notificationParams{
+			transactionToNotificationParams(t, fireflyBaseURL),
+			transactionToNotificationParams(original, fireflyBaseURL),
+		},
+	}, &menu)
+}
+
+// NotifyRecurringPayment implements interface transactionNotifier
+func (b *TelegramBot) NotifyRecurringPayment(t *structs.TransactionRead, repeatFreq string, fireflyBaseURL string) error {
+	menu := tele.ReplyMarkup{}
+	menu.Inline(menu.Row(
+		menu.Data("📅 Abonnement anlegen", buttonUniqueCreateBill, t.Id),
+		menu.Data("✖️ Ignorieren", t.Id+buttonDataDone, buttonDataDone),
+	))
+	b.addRevertButton(&menu, t)
+	frequency, ok := repeatFrequencies[repeatFreq]
+	if !ok {
+		frequency = repeatFreq
+	}
+	return b.sendQuestion(&questionNotificationParams{
+		Title:        "🔄 Wiederkehrende Zahlung 🔄",
+		Question:     fmt.Sprintf("Diese Zahlung scheint %s wiederzukehren. Soll ein Abonnement dafür angelegt werden?", frequency),
+		Transactions: []*notificationParams{transactionToNotificationParams(t, fireflyBaseURL)},
+	}, &menu)
+}
+
+func (b *TelegramBot) sendQuestion(params *questionNotificationParams, menu *tele.ReplyMarkup) error {
+	body := bytes.NewBufferString(``)
+	if err := questionTemplate.Execute(body, params); err != nil {
+		return err
+	}
+	msg, err := b.bot.Send(
+		b.targetChat,
+		body.String(),
+		menu,
+		tele.ModeHTML,
+	)
+	if err != nil {
+		return err
+	}
+	transactionIDs := make([]string, len(params.Transactions))
+	for i, transaction := range params.Transactions {
+		transactionIDs[i] = transaction.TransactionID
+	}
+	b.rememberMessage(msg, transactionIDs...)
+	return nil
+}
+
+// rememberMessage remembers the notification about the transactions, to disable it when one of them is deleted.
+func (b *TelegramBot) rememberMessage(msg *tele.Message, transactionIDs ...string) {
+	if err := b.messages.add(msg, transactionIDs...); err != nil {
+		log.Println("WARNING: could not save sent notification:", err)
+	}
+}
+
+// forgetMessage stops disabling the notification when the transaction is deleted.
+func (b *TelegramBot) forgetMessage(transactionID string, msg *tele.Message) {
+	if err := b.messages.forget(transactionID, msg.ID); err != nil {
+		log.Println("WARNING: could not save sent notifications:", err)
+	}
+}
+
+// NotifyTransactionDestroyed implements interface transactionNotifier.
+// The notifications about the transaction are replaced by a note and their buttons are removed.
+func (b *TelegramBot) NotifyTransactionDestroyed(id string) error {
+	messages, err := b.messages.remove(id)
+	if err != nil {
+		log.Println("WARNING: could not save sent notifications:", err)
+	}
+	body := fmt.Sprintf("<b>🗑️ Gelöschte Firefly-III-Transaktion 🗑️</b>\n<s>Transaktion #%s</s> wurde in Firefly-III gelöscht.", id)
+	var errs []error
+	for _, msg := range messages {
+		if _, err = b.bot.Edit(msg, body, &tele.ReplyMarkup{}, tele.ModeHTML); err != nil {
+			errs = append(errs, fmt.Errorf("could not edit notification %d: %w", msg.MessageId, err))
+		}
+	}
+	log.Printf(">> %d notifications updated", len(messages)-len(errs))
+	return errors.Join(errs...)
 }
 
 func newTransactionNotification(date string, sourceName string, destName string, amount string, currencySymbol string, categoryName string, description string) *transactionNotification {
@@ -282,6 +678,47 @@ func (b *TelegramBot) NotifyError(err error) error {
 	return nil
 }
 
+// NotifyConnection reports that requests to the host were stopped after repeated failures or that it is available again.
+func (b *TelegramBot) NotifyConnection(host string, open bool) error {
+	body := fmt.Sprintf("<b>✅ Verbindung wiederhergestellt ✅</b>\n\n%s ist wieder erreichbar.", template.HTMLEscapeString(host))
+	if open {
+		log.Printf("WARNING: requests to %s are failing, pausing them", host)
+		body = fmt.Sprintf("<b>🔌 Verbindung unterbrochen 🔌</b>\n\nAnfragen an %s schlagen wiederholt fehl und werden vorübergehend ausgesetzt.", template.HTMLEscapeString(host))
+	} else {
+		log.Printf("Requests to %s are succeeding again", host)
+	}
+	_, err := b.bot.Send(b.targetChat, body, tele.ModeHTML)
+	return err
+}
+
+// NotifyReadiness implements interface transactionNotifier
+func (b *TelegramBot) NotifyReadiness(ready bool, webhookURL string, cause error) error {
+	body := fmt.Sprintf("<b>✅ Webhooks erreichbar ✅</b>\n\n%s ist wieder erreichbar, neue Transaktionen werden wieder bearbeitet.", template.HTMLEscapeString(webhookURL))
+	if !ready {
+		body = fmt.Sprintf("<b>⚠️ Webhooks nicht erreichbar ⚠️</b>\n\n%s ist nicht erreichbar, Firefly-III kann keine Transaktionen senden.\n\n<i>%s</i>",
+			template.HTMLEscapeString(webhookURL), template.HTMLEscapeString(cause.Error()))
+	}
+	_, err := b.bot.Send(b.targetChat, body, tele.ModeHTML)
+	return err
+}
+
+// NotifyDiagnostics implements interface diagnosticsNotifier
+func (b *TelegramBot) NotifyDiagnostics(diagnostics []diagnostic) error {
+	body := "<b>🩺 Startdiagnose 🩺</b>\n"
+	for _, d := range diagnostics {
+		icon := "✅"
+		switch d.Status {
+		case diagnosticWarning:
+			icon = "⚠️"
+		case diagnosticFailed:
+			icon = "❌"
+		}
+		body += fmt.Sprintf("\n%s <b>%s</b>: %s", icon, template.HTMLEscapeString(d.Name), template.HTMLEscapeString(d.Detail))
+	}
+	_, err := b.bot.Send(b.targetChat, body, tele.ModeHTML)
+	return err
+}
+
 func formatStr(s string, maxLen int) string {
 	if len(s) > maxLen {
 		s = s[:maxLen-3] + "..."
@@ -0,0 +1,191 @@
+package worker
+
+import (
+	"errors"
+	"firefly-iii-fix-ing/internal/firefly"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// findTransferCounterpart looks for the other side of an internal transfer between our own accounts.
+//
+// The importer creates a withdrawal on one account and a deposit on the other instead of a single transfer.
+// If the counterparty of the transaction is one of our asset accounts, its transactions of opposite type
+// with the same amount are searched within the configured date window, closest date first.
+// Returns nil if there is no such transaction.
+func (f *fireflyAPI) findTransferCounterpart(t *structs.TransactionRead) (*structs.TransactionRead, error) {
+	if len(t.Attributes.Transactions) != 1 {
+		return nil, nil
+	}
+	split := t.Attributes.Transactions[0]
+	var oppositeType, counterpartyName, counterpartyIban, ownAccountID string
+	switch split.Type {
+	case "withdrawal":
+		oppositeType = "deposit"
+		counterpartyName, counterpartyIban, ownAccountID = split.DestinationName, split.DestinationIban, split.SourceId
+	case "deposit":
+		oppositeType = "withdrawal"
+		counterpartyName, counterpartyIban, ownAccountID = split.SourceName, split.SourceIban, split.DestinationId
+	default:
+		return nil, nil
+	}
+
+	accounts, err := f.getAssetAccounts()
+	if err != nil {
+		return nil, err
+	}
+	var counterpartyAccount *structs.AccountRead
+	for i, account := range accounts {
+		if account.Id == ownAccountID {
+			continue
+		}
+		if strings.EqualFold(account.Attributes.Name, counterpartyName) ||
+			(counterpartyIban != "" && normalizeIban(account.Attributes.Iban) == normalizeIban(counterpartyIban)) {
+			counterpartyAccount = &accounts[i]
+			break
+		}
+	}
+	if counterpartyAccount == nil {
+		return nil, nil
+	}
+	log.Printf(">> Counterparty is own account '%s', looking for matching %s...", counterpartyAccount.Attributes.Name, oppositeType)
+
+	date, err := time.Parse(time.RFC3339, split.Date)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse date of transaction #%s: %w", t.Id, err)
+	}
+	candidates, err := f.getAccountTransactions(counterpartyAccount.Id, date.Add(-f.transferDateWindow), date.Add(f.transferDateWindow), oppositeType)
+	if err != nil {
+		return nil, err
+	}
+	var counterpart *structs.TransactionRead
+	var counterpartDistance time.Duration
+	for i, candidate := range candidates {
+		if candidate.Id == t.Id || len(candidate.Attributes.Transactions) != 1 {
+			continue
+		}
+		candidateSplit := candidate.Attributes.Transactions[0]
+		if candidateSplit.Type != oppositeType || !equalAmounts(candidateSplit.Amount, split.Amount) {
+			continue
+		}
+		candidateDate, err := time.Parse(time.RFC3339, candidateSplit.Date)
+		if err != nil {
+			continue
+		}
+		distance := candidateDate.Sub(date).Abs()
+		if counterpart == nil || distance < counterpartDistance {
+			counterpart = &candidates[i]
+			counterpartDistance = distance
+		}
+	}
+	return counterpart, nil
+}
+
+// errAlreadyMerged is returned if transactions cannot be merged, because one of them was deleted or is a transfer already.
+var errAlreadyMerged = errors.New("transactions were already merged or deleted")
+
+// mergeTransfer converts the transaction into a transfer between the accounts of both transactions and deletes the counterpart.
+// Both transactions are read again while holding mergeMu, as the jobs of both sides of a transfer find each other as counterpart.
+// Pending jobs of the deleted counterpart are removed from the queue.
+func (f *fireflyAPI) mergeTransfer(id int, counterpartID int) (*structs.TransactionRead, error) {
+	f.mergeMu.Lock()
+	defer f.mergeMu.Unlock()
+	t, err := f.getTransaction(id)
+	if err != nil {
+		if firefly.IsNotFound(err) {
+			return nil, errAlreadyMerged
+		}
+		return nil, err
+	}
+	counterpart, err := f.getTransaction(counterpartID)
+	if err != nil {
+		if firefly.IsNotFound(err) {
+			return nil, errAlreadyMerged
+		}
+		return nil, err
+	}
+	if len(t.Attributes.Transactions) != 1 || len(counterpart.Attributes.Transactions) != 1 {
+		return nil, errors.New("only transactions with a single split can be merged")
+	}
+	split := t.Attributes.Transactions[0]
+	journalID, err := strconv.Atoi(split.JournalId)
+	if err != nil {
+		return nil, err
+	}
+	withdrawal, deposit := split, counterpart.Attributes.Transactions[0]
+	if split.Type == "deposit" {
+		withdrawal, deposit = deposit, split
+	}
+	if withdrawal.Type == "transfer" || deposit.Type == "transfer" {
+		return nil, errAlreadyMerged
+	}
+	if withdrawal.Type != "withdrawal" || deposit.Type != "deposit" {
+		return nil, fmt.Errorf("cannot merge %s and %s into transfer", withdrawal.Type, deposit.Type)
+	}
+
+	log.Printf(">> Merging transactions #%d and #%d into transfer...", id, counterpartID)
+	updateObj := &structs.TransactionUpdate{
+		ApplyRules:   true,
+		FireWebhooks: false,
+		GroupTitle:   t.Attributes.GroupTitle,
+		TransactionUpdates: []structs.TransactionSplitUpdate{{
+			JournalId:     journalID,
+			Type:          "transfer",
+			SourceId:      structs.Id(withdrawal.SourceId),
+			DestinationId: structs.Id(deposit.DestinationId),
+		}},
+	}
+	updated, err := f.updateTransactionAudited(id, updateObj, singleSource(auditSourceTransfers))
+	if err != nil {
+		return nil, err
+	}
+	if err = f.deleteTransactionAudited(counterpartID, auditSourceTransfers); err != nil {
+		return nil, fmt.Errorf("transaction #%d converted to transfer, but could not delete #%d: %w", id, counterpartID, err)
+	}
+	if f.queue != nil {
+		for _, wh := range f.webhooks {
+			if wh.attributes.Trigger == "DESTROY_TRANSACTION" {
+				continue
+			}
+			if err = f.queue.Remove(jobKey(wh.attributes.Trigger, counterpartID)); err != nil {
+				log.Println("WARNING: could not remove jobs of deleted transaction from queue:", err)
+			}
+		}
+	}
+	return updated, nil
+}
+
+// MergeTransfer implements interface transactionUpdater
+func (f *fireflyAPI) MergeTransfer(id int, counterpartID int) (*structs.TransactionRead, error) {
+	return f.mergeTransfer(id, counterpartID)
+}
+
+// getAssetAccounts returns all asset accounts, reading all pages.
+func (f *fireflyAPI) getAssetAccounts() ([]structs.AccountRead, error) {
+	return f.client.Accounts("asset").All(f.ctx)
+}
+
+// getAccountTransactions returns all transactions of the given type of an account between start and end.
+func (f *fireflyAPI) getAccountTransactions(accountID string, start time.Time, end time.Time, transactionType string) ([]structs.TransactionRead, error) {
+	return f.client.AccountTransactions(accountID, firefly.TransactionFilter{Start: start, End: end, Type: transactionType}).All(f.ctx)
+}
+
+func (f *fireflyAPI) deleteTransaction(id string) error {
+	return f.client.DeleteTransaction(f.ctx, id)
+}
+
+// equalAmounts compares two decimal amounts, ignoring their sign.
+func equalAmounts(a string, b string) bool {
+	ra, okA := new(big.Rat).SetString(a)
+	rb, okB := new(big.Rat).SetString(b)
+	return okA && okB && ra.Abs(ra).Cmp(rb.Abs(rb)) == 0
+}
+
+func normalizeIban(iban string) string {
+	return strings.ToUpper(strings.ReplaceAll(iban, " ", ""))
+}
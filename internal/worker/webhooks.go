@@ -0,0 +1,270 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"firefly-iii-fix-ing/internal/structs"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	webhookPathUpdate  = "/update"
+	webhookPathDestroy = "/destroy"
+	// updateLoopWindow is the time after an update by this service in which update webhooks for the transaction are ignored
+	updateLoopWindow = 30 * time.Second
+)
+
+// webhook is a webhook in Firefly for a single trigger, delivered to its own path.
+type webhook struct {
+	// path is appended to the URL of the webhook for new transactions, empty for that webhook
+	path       string
+	attributes structs.WebhookAttributes
+	// secret is the secret Firefly signs deliveries with, set by createOrUpdateWebhooks
+	secret string
+	// handler processes a delivery, steps record its side effects to skip them on retries
+	handler func(t structs.WhTransactionRead, steps *jobSteps) error
+}
+
+// newWebhooks returns the webhooks for new, updated and deleted transactions.
+func (f *fireflyAPI) newWebhooks(url string) []*webhook {
+	newWebhook := func(path string, title string, trigger string, handler func(t structs.WhTransactionRead, steps *jobSteps) error) *webhook {
+		return &webhook{
+			path: path,
+			attributes: structs.WebhookAttributes{
+				Active:   true,
+				Title:    title,
+				Response: "TRANSACTIONS",
+				Delivery: "JSON",
+				Trigger:  trigger,
+				Url:      url + path,
+			},
+			handler: handler,
+		}
+	}
+	return []*webhook{
+		newWebhook("", "Fix ING transaction descriptions from Importer", "STORE_TRANSACTION", f.checkAndUpdateTransaction),
+		newWebhook(webhookPathUpdate, "Fix ING updated transactions", "UPDATE_TRANSACTION", f.checkUpdatedTransaction),
+		newWebhook(webhookPathDestroy, "Fix ING deleted transactions", "DESTROY_TRANSACTION", f.handleDestroyedTransaction),
+	}
+}
+
+// newWebhookRoutes returns the paths of the webhooks below webhookPath and, for reverse proxies stripping it,
+// the paths without webhookPath.
+func newWebhookRoutes(webhookPath string, webhooks []*webhook) map[string]*webhook {
+	routes := map[string]*webhook{}
+	for _, wh := range webhooks {
+		for _, path := range []string{strings.TrimSuffix(webhookPath, "/") + wh.path, wh.path} {
+			if path == "" {
+				path = "/"
+			}
+			routes[path] = wh
+		}
+	}
+	return routes
+}
+
+// handleWebhooks routes deliveries to the webhook registered for the path. Other paths are not found.
+func (f *fireflyAPI) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+	wh, ok := f.webhookRoutes[path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	f.handleWebhook(wh, w, r)
+}
+
+// handleWebhook queues deliveries of the webhook after verifying their signature.
+// Other requests than POST are answered without processing, they are used to validate the connection.
+func (f *fireflyAPI) handleWebhook(wh *webhook, w http.ResponseWriter, r *http.Request) {
+	var target struct {
+		Version string                    `json:"version"`
+		Data    structs.WhTransactionRead `json:"content"`
+	}
+	defer func() {
+		if errClose := r.Body.Close(); errClose != nil {
+			log.Printf("WARNING: error closing request body: %v", errClose)
+		}
+	}()
+	if r.Method != http.MethodPost {
+		return
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Println("WARNING: could not read request body:", err)
+		http.Error(w, "could not read body", http.StatusBadRequest)
+		return
+	}
+	if err = verifySignature(r.Header.Get(signatureHeader), body, wh.secret, time.Now()); errors.Is(err, errSignatureMalformed) {
+		log.Printf("WARNING: received request from %s with %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("WARNING: received request from %s with %v", r.RemoteAddr, err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if err = json.Unmarshal(body, &target); err != nil || target.Version == "" {
+		log.Println("WARNING: received request with invalid body structure")
+		http.Error(w, "invalid body structure", http.StatusBadRequest)
+		return
+	}
+
+	if err = f.enqueue(wh, target.Data); err != nil {
+		log.Printf("WARNING: could not queue %s for transaction #%d: %v", wh.attributes.Trigger, target.Data.Id, err)
+		http.Error(w, "could not queue delivery", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Queued %s for transaction #%d", wh.attributes.Trigger, target.Data.Id)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// checkUpdatedTransaction runs the modules again after a transaction was edited.
+// Updates by this service are sent without firing webhooks, recently updated transactions are skipped nonetheless to prevent loops.
+func (f *fireflyAPI) checkUpdatedTransaction(t structs.WhTransactionRead, _ *jobSteps) error {
+	if f.recentlyUpdated(t.Id) {
+		log.Printf(">> Transaction #%d was just updated by this service, skipping", t.Id)
+		return nil
+	}
+	updateObj, sources := f.processTransaction(t)
+	if updateObj == nil {
+		log.Println(">>>> No fix applied")
+		return nil
+	}
+	if _, err := f.updateTransactionAudited(t.Id, updateObj, sources.source); err != nil {
+		return err
+	}
+	log.Println(">> Success.")
+	return nil
+}
+
+// handleDestroyedTransaction disables the notifications about a deleted transaction.
+func (f *fireflyAPI) handleDestroyedTransaction(t structs.WhTransactionRead, _ *jobSteps) error {
+	log.Printf(">> Transaction #%d was deleted, updating notifications...", t.Id)
+	return f.notifManager.NotifyTransactionDestroyed(strconv.Itoa(t.Id))
+}
+
+// markUpdated records that the transaction was just updated by this service.
+func (f *fireflyAPI) markUpdated(id int) {
+	f.recentUpdatesMu.Lock()
+	defer f.recentUpdatesMu.Unlock()
+	if f.recentUpdates == nil {
+		f.recentUpdates = map[int]time.Time{}
+	}
+	for updatedID, updated := range f.recentUpdates {
+		if time.Since(updated) > updateLoopWindow {
+			delete(f.recentUpdates, updatedID)
+		}
+	}
+	f.recentUpdates[id] = time.Now()
+}
+
+func (f *fireflyAPI) recentlyUpdated(id int) bool {
+	f.recentUpdatesMu.Lock()
+	defer f.recentUpdatesMu.Unlock()
+	updated, ok := f.recentUpdates[id]
+	return ok && time.Since(updated) <= updateLoopWindow
+}
+
+// createOrUpdateWebhooks ensures all webhooks exist in Firefly and returns their URLs.
+func (f *fireflyAPI) createOrUpdateWebhooks() ([]string, error) {
+	urls := make([]string, len(f.webhooks))
+	for i, wh := range f.webhooks {
+		url, err := f.createOrUpdateWebhook(wh)
+		if err != nil {
+			return nil, fmt.Errorf("webhook '%s': %w", wh.attributes.Title, err)
+		}
+		urls[i] = url
+	}
+	return urls, nil
+}
+
+func (f *fireflyAPI) createOrUpdateWebhook(wh *webhook) (url string, err error) {
+	var result *structs.WhUrlResult
+	result, err = f.getWebhook(wh)
+	if err != nil {
+		return
+	}
+	if result.Exists && !result.NeedsUpdate {
+		url = result.Wh.Attributes.Url
+		err = wh.setSecret(result.Wh)
+		return
+	}
+
+	var read *structs.WebhookRead
+	if !result.Exists {
+		// create
+		log.Printf("webhook with title '%s' does not exist, creating a new one", wh.attributes.Title)
+		read, err = f.client.CreateWebhook(f.ctx, wh.attributes)
+	} else {
+		// update
+		log.Printf("webhook with title '%s' exists, but requires update", wh.attributes.Title)
+		read, err = f.client.UpdateWebhook(f.ctx, result.Wh.Id, wh.attributes)
+	}
+	if err != nil {
+		return
+	}
+	url = read.Attributes.Url
+	err = wh.setSecret(read)
+	return
+}
+
+// setSecret stores the secret of the webhook in Firefly to verify deliveries.
+func (wh *webhook) setSecret(read *structs.WebhookRead) error {
+	if read.Attributes.Secret == "" {
+		return errors.New("webhook has no secret, cannot verify deliveries")
+	}
+	wh.secret = read.Attributes.Secret
+	return nil
+}
+
+func (f *fireflyAPI) getWebhook(target *webhook) (*structs.WhUrlResult, error) {
+	wh, err := f.findWebhookByTitle(target.attributes.Title)
+	if err != nil {
+		return nil, err
+	}
+	if wh == nil {
+		return &structs.WhUrlResult{
+			Exists:      false,
+			NeedsUpdate: false,
+		}, nil
+	}
+
+	if !wh.Attributes.Active ||
+		wh.Attributes.Delivery != target.attributes.Delivery ||
+		wh.Attributes.Response != target.attributes.Response ||
+		wh.Attributes.Trigger != target.attributes.Trigger ||
+		wh.Attributes.Url != target.attributes.Url {
+		return &structs.WhUrlResult{
+			Exists:      true,
+			NeedsUpdate: true,
+			Wh:          wh,
+		}, nil
+	}
+
+	return &structs.WhUrlResult{
+		Exists:      true,
+		NeedsUpdate: false,
+		Wh:          wh,
+	}, nil
+}
+
+// findWebhookByTitle returns the webhook with the given title, reading all pages, or nil if none exists.
+func (f *fireflyAPI) findWebhookByTitle(title string) (*structs.WebhookRead, error) {
+	it := f.client.Webhooks()
+	for it.Next(f.ctx) {
+		if wh := it.Item(); wh.Attributes.Title == title {
+			return &wh, nil
+		}
+	}
+	return nil, it.Err()
+}
@@ -2,8 +2,12 @@
 package worker
 
 import (
+	"context"
+	"firefly-iii-fix-ing/internal/audit"
 	"firefly-iii-fix-ing/internal/autoimport"
+	"firefly-iii-fix-ing/internal/classifier"
 	"firefly-iii-fix-ing/internal/modules"
+	"firefly-iii-fix-ing/internal/transport"
 	"fmt"
 	"log"
 	"net/http"
@@ -22,6 +26,9 @@ type Worker struct {
 	scheduler       *gocron.Scheduler
 	healthchecksURL string
 	httpClient      *http.Client
+	shutdownTimeout time.Duration
+	// jobs tracks the scheduled jobs in progress, to wait for them on shutdown
+	jobs activities
 }
 
 // FireflyOptions holds options for the Firefly III instance
@@ -39,16 +46,89 @@ type AutoimportOptions struct {
 	HealthchecksURL string
 }
 
+// ModuleOptions holds options for the transaction modules
+type ModuleOptions struct {
+	RulesDir    string
+	AliasesPath string
+	// AuditPath is the database of the audit journal recording the changes to transactions, empty to disable it
+	AuditPath string
+}
+
+// ClassifierOptions holds options for the category classifier
+type ClassifierOptions struct {
+	ModelPath string
+	// AutoApplyThreshold is the probability above which a suggested category is applied without asking, 0 to disable
+	AutoApplyThreshold float64
+}
+
+// TransferOptions holds options for detecting internal transfers between own accounts
+type TransferOptions struct {
+	// AutoMerge merges detected transfers without asking
+	AutoMerge bool
+	// DateWindowDays is the maximum number of days between both sides of a transfer
+	DateWindowDays int
+}
+
+// DuplicateOptions holds options for detecting duplicate transactions
+type DuplicateOptions struct {
+	// Action is one of DuplicateActionOff, DuplicateActionTag, DuplicateActionNotify and DuplicateActionDelete
+	Action string
+}
+
+// QueueOptions holds options for processing webhook deliveries
+type QueueOptions struct {
+	// Path is the database persisting the deliveries until they were processed
+	Path string
+	// Workers is the number of deliveries processed in parallel
+	Workers int
+	// MaxAttempts is the number of attempts after which a delivery is moved to the dead jobs
+	MaxAttempts int
+}
+
+// ServerOptions holds options for the server receiving webhooks
+type ServerOptions struct {
+	// BindAddress is the address the server listens on, empty for all addresses
+	BindAddress string
+	Port        int
+	// WebhookPath is the path webhooks are received on
+	WebhookPath string
+	// PublicURL is the URL Firefly sends webhooks to, empty for the Firefly base URL with the webhook path
+	PublicURL string
+	// ShutdownTimeout limits waiting for the work in progress on shutdown
+	ShutdownTimeout time.Duration
+}
+
+// HTTPOptions holds options for requests to Firefly, the autoimporter and healthchecks
+type HTTPOptions struct {
+	// Timeout limits a request to Firefly including all retries
+	Timeout time.Duration
+	// MaxRetries is the number of retries of failed requests
+	MaxRetries int
+	// BreakerThreshold is the number of consecutive failed requests after which requests to a host are stopped, 0 to disable
+	BreakerThreshold int
+	// BreakerCooldown is the time after which a stopped host is tried again
+	BreakerCooldown time.Duration
+}
+
 // TelegramOptions holds options for the telegram worker
 type TelegramOptions struct {
 	AccessToken string
 	ChatID      int64
+	// MessagesPath is the file remembering the notifications sent for each transaction
+	MessagesPath string
 }
 
-const cronTag = "autoimport"
+const (
+	cronTag           = "autoimport"
+	cronTagClassifier = "classifier"
+	// classifierMaxAge is the age after which the classifier is retrained from all transactions
+	classifierMaxAge = 7 * 24 * time.Hour
+	// healthchecksTimeout limits pinging healthchecks
+	healthchecksTimeout = 10 * time.Second
+)
 
 // NewWorker creates a new worker instance*/
-func NewWorker(fireflyOptions FireflyOptions, autoimportOptions AutoimportOptions, telegramOptions TelegramOptions) (*Worker, error) {
+func NewWorker(fireflyOptions FireflyOptions, autoimportOptions AutoimportOptions, telegramOptions TelegramOptions, moduleOptions ModuleOptions, classifierOptions ClassifierOptions, transferOptions TransferOptions, duplicateOptions DuplicateOptions, queueOptions QueueOptions, httpOptions HTTPOptions, serverOptions ServerOptions) (*Worker, error) {
 	// remove trailing slash from Firefly III base URL
 	fireflyOptions.BaseURL = strings.TrimSuffix(fireflyOptions.BaseURL, "/")
 
@@ -57,14 +137,56 @@ func NewWorker(fireflyOptions FireflyOptions, autoimportOptions AutoimportOption
 		return nil, err
 	}
 
+	aliases, err := modules.NewAliasStore(moduleOptions.AliasesPath)
+	if err != nil {
+		return nil, err
+	}
+	bot.aliases = aliases
+
+	if bot.messages, err = newMessageStore(telegramOptions.MessagesPath); err != nil {
+		return nil, err
+	}
+
+	moduleHandler, err := modules.NewModuleHandler(moduleOptions.RulesDir, aliases)
+	if err != nil {
+		return nil, err
+	}
+
+	categoryClassifier, err := classifier.New(classifierOptions.ModelPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not load classifier model: %w", err)
+	}
+
+	transportOptions := transport.DefaultOptions()
+	transportOptions.MaxRetries = httpOptions.MaxRetries
+	transportOptions.BreakerThreshold = httpOptions.BreakerThreshold
+	transportOptions.BreakerCooldown = httpOptions.BreakerCooldown
+	transportOptions.OnBreakerChange = func(host string, open bool) {
+		// notify asynchronously, as the request of the change is still in progress
+		go func() {
+			if err := bot.NotifyConnection(host, open); err != nil {
+				log.Println("WARNING: could not send notification:", err)
+			}
+		}()
+	}
+	sharedTransport := transport.New(transportOptions)
+
 	fireflyAPI := newFireflyAPI(
 		fireflyOptions,
-		modules.NewModuleHandler(),
+		moduleHandler,
+		newJournal(moduleOptions),
 		bot,
+		categoryClassifier,
+		classifierOptions,
+		transferOptions,
+		duplicateOptions,
+		queueOptions,
+		&http.Client{Timeout: httpOptions.Timeout, Transport: sharedTransport},
+		serverOptions,
 	)
 	bot.transactionUpdater = fireflyAPI
 
-	autoimporter, err := autoimport.NewManager(autoimportOptions.URL, autoimportOptions.Port, autoimportOptions.Secret)
+	autoimporter, err := autoimport.NewManager(autoimportOptions.URL, autoimportOptions.Port, autoimportOptions.Secret, sharedTransport)
 	if err != nil {
 		return nil, err
 	}
@@ -78,8 +200,10 @@ func NewWorker(fireflyOptions FireflyOptions, autoimportOptions AutoimportOption
 		autoimporter:    autoimporter,
 		scheduler:       scheduler,
 		healthchecksURL: autoimportOptions.HealthchecksURL,
+		shutdownTimeout: serverOptions.ShutdownTimeout,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout:   healthchecksTimeout,
+			Transport: sharedTransport,
 		},
 	}
 
@@ -92,11 +216,75 @@ func NewWorker(fireflyOptions FireflyOptions, autoimportOptions AutoimportOption
 	}
 	log.Printf(">> Autoimport scheduled with cron '%s'", autoimportOptions.CronSchedule)
 
+	if _, err = scheduler.Every(1).Day().At("04:00").Tag(cronTagClassifier).Do(w.TrainClassifierIfStale); err != nil {
+		return nil, err
+	}
+
 	return w, nil
 }
 
+// newJournal returns the audit journal, nil if it is disabled.
+func newJournal(moduleOptions ModuleOptions) *audit.Journal {
+	if moduleOptions.AuditPath == "" {
+		return nil
+	}
+	return audit.New(moduleOptions.AuditPath)
+}
+
+// TrainClassifierIfStale retrains the category classifier from all categorized transactions if it is outdated.
+func (w *Worker) TrainClassifierIfStale() {
+	if !w.jobs.begin() {
+		return
+	}
+	defer w.jobs.end()
+	if trainedAt := w.fireflyAPI.classifier.TrainedAt(); time.Since(trainedAt) < classifierMaxAge {
+		return
+	}
+	log.Println("Training category classifier...")
+	transactions, err := w.fireflyAPI.getAllTransactions()
+	if err != nil {
+		log.Println(">> WARNING: could not retrieve transactions:", err)
+		return
+	}
+	var samples []classifier.LabeledSample
+	for _, t := range transactions {
+		for _, split := range t.Attributes.Transactions {
+			if split.CategoryName == "" {
+				continue
+			}
+			samples = append(samples, classifier.LabeledSample{
+				Sample:   newClassifierSample(split.Description, split.SourceName, split.DestinationName, split.Type),
+				Category: split.CategoryName,
+			})
+		}
+	}
+	w.fireflyAPI.classifier.Train(samples)
+	if err = w.fireflyAPI.classifier.Save(); err != nil {
+		log.Println(">> WARNING: could not save classifier model:", err)
+	}
+	log.Printf(">> Trained on %d categorized splits", len(samples))
+}
+
+// newClassifierSample extracts the classifier features from a split.
+func newClassifierSample(description string, sourceName string, destinationName string, transactionType string) classifier.Sample {
+	counterparty := destinationName
+	if transactionType == "deposit" {
+		counterparty = sourceName
+	}
+	return classifier.Sample{
+		Description:  description,
+		Counterparty: counterparty,
+		Outgoing:     transactionType != "deposit",
+	}
+}
+
 // Autoimport runs the autoimport, messages healthchecks if needed and changes the config files afterwards*/
 func (w *Worker) Autoimport() {
+	if !w.jobs.begin() {
+		log.Println("Shutting down, skipping autoimport")
+		return
+	}
+	defer w.jobs.end()
 	w.pingHealthchecks(healthchecksStart)
 	log.Println("Running autoimport...")
 
@@ -148,9 +336,13 @@ func (w *Worker) pingHealthchecks(typ healthchecksType) {
 			healthchecksURL += "/fail"
 		}
 		log.Printf("Pinging %s...", healthchecksURL)
-		_, err := w.httpClient.Head(healthchecksURL)
+		resp, err := w.httpClient.Head(healthchecksURL)
 		if err != nil {
 			log.Println("WARNING: could not ping healthchecks:", err)
+			return
+		}
+		if err = resp.Body.Close(); err != nil {
+			log.Printf("WARNING: error closing response body: %v", err)
 		}
 	}
 }
@@ -164,29 +356,61 @@ func (w *Worker) getNextAutoimportAsString() string {
 	return jobs[0].NextRun().Format("02.01.2006 15:04:05")
 }
 
-// Listen starts webserver and ensures a webhook in Firefly exists, pointing to this server
-func (w *Worker) Listen() error {
-	log.Println("Ensuring webhook exists...")
-	url, err := w.fireflyAPI.createOrUpdateWebhook()
+// Listen starts webserver and ensures a webhook in Firefly exists, pointing to this server.
+// When the context is done, the worker is shut down gracefully. Blocking.
+func (w *Worker) Listen(ctx context.Context) error {
+	if err := w.fireflyAPI.runDiagnostics(w.telegramBot); err != nil {
+		return err
+	}
+	log.Println()
+
+	log.Println("Ensuring webhooks exist...")
+	urls, err := w.fireflyAPI.createOrUpdateWebhooks()
 	if err != nil {
 		return fmt.Errorf(">> error occured: %w", err)
 	}
-	log.Println(">> Webhook ready at", url)
+	for _, url := range urls {
+		log.Println(">> Webhook ready at", url)
+	}
 	log.Println()
 
 	// start telegram bot
 	go w.telegramBot.Listen()
 	w.scheduler.StartAsync()
 
+	go w.TrainClassifierIfStale()
+
 	// run immediately if not schedule in next 3 minutes
-	if _, nextRun := w.scheduler.NextRun(); time.Until(nextRun).Minutes() >= 3 {
+	if jobs, err := w.scheduler.FindJobsByTag(cronTag); err == nil && time.Until(jobs[0].NextRun()).Minutes() >= 3 {
 		go func() {
-			time.Sleep(10 * time.Second)
-			w.Autoimport()
+			select {
+			case <-time.After(10 * time.Second):
+				w.Autoimport()
+			case <-ctx.Done():
+			}
 		}()
 	}
 
 	log.Println("Next autoimport scheduled for", w.getNextAutoimportAsString())
 	log.Println()
-	return w.fireflyAPI.Listen()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- w.fireflyAPI.Listen()
+	}()
+	select {
+	case err = <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println()
+	log.Printf("Shutting down, waiting up to %s for work in progress...", w.shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), w.shutdownTimeout)
+	defer cancel()
+	if err = w.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("could not shut down gracefully: %w", err)
+	}
+	log.Println("Shut down gracefully")
+	return nil
 }
@@ -2,11 +2,18 @@
 package main
 
 import (
+	"context"
 	"firefly-iii-fix-ing/internal/worker"
 	"log"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"slices"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var version = "dev"
@@ -21,9 +28,52 @@ const (
 	envTelegramToken        = "TELEGRAM_ACCESS_TOKEN"
 	envTelegramChatID       = "TELEGRAM_CHAT_ID"
 	envHealthchecksURL      = "HEALTHCHECKS_URL"
+	envModulesRulesDir      = "MODULES_RULES_DIR"
+	envDataDir              = "DATA_DIR"
+	envClassifierThreshold  = "CLASSIFIER_AUTO_APPLY_THRESHOLD"
+	envTransferAutoMerge    = "TRANSFER_AUTO_MERGE"
+	envTransferDateWindow   = "TRANSFER_DATE_WINDOW_DAYS"
+	envDuplicateAction      = "DUPLICATE_ACTION"
+	envQueueWorkers         = "QUEUE_WORKERS"
+	envQueueMaxAttempts     = "QUEUE_MAX_ATTEMPTS"
+	envHTTPTimeout          = "HTTP_TIMEOUT_SECONDS"
+	envHTTPMaxRetries       = "HTTP_MAX_RETRIES"
+	envHTTPBreakerThreshold = "HTTP_BREAKER_THRESHOLD"
+	envHTTPBreakerCooldown  = "HTTP_BREAKER_COOLDOWN_SECONDS"
+	envListenAddress        = "LISTEN_ADDRESS"
+	envListenPort           = "LISTEN_PORT"
+	envWebhookPath          = "WEBHOOK_PATH"
+	envWebhookPublicURL     = "WEBHOOK_PUBLIC_URL"
+	envShutdownTimeout      = "SHUTDOWN_TIMEOUT_SECONDS"
+)
+
+const (
+	defaultDataDir              = "/data"
+	defaultTransferDateWindow   = 3
+	defaultQueueWorkers         = 2
+	defaultQueueMaxAttempts     = 8
+	defaultHTTPTimeout          = 60
+	defaultHTTPMaxRetries       = 3
+	defaultHTTPBreakerThreshold = 5
+	defaultHTTPBreakerCooldown  = 60
+	defaultListenPort           = 8822
+	defaultWebhookPath          = "/wh_fix_ing"
+	// defaultShutdownTimeout is below the 10 seconds Docker waits before killing the container
+	defaultShutdownTimeout = 8
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		command, ok := commands[os.Args[1]]
+		if !ok {
+			log.Fatalln("unknown command", os.Args[1])
+		}
+		if err := command(os.Args[2:]); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	envMap := map[string]string{
 		envBaseURL:              "",
 		envAccessToken:          "",
@@ -34,9 +84,43 @@ func main() {
 		envAutoimporterSecret:   "",
 		envAutoimporterSchedule: "",
 		envHealthchecksURL:      "",
+		envModulesRulesDir:      "",
+		envDataDir:              "",
+		envClassifierThreshold:  "",
+		envTransferAutoMerge:    "",
+		envTransferDateWindow:   "",
+		envDuplicateAction:      "",
+		envQueueWorkers:         "",
+		envQueueMaxAttempts:     "",
+		envHTTPTimeout:          "",
+		envHTTPMaxRetries:       "",
+		envHTTPBreakerThreshold: "",
+		envHTTPBreakerCooldown:  "",
+		envListenAddress:        "",
+		envListenPort:           "",
+		envWebhookPath:          "",
+		envWebhookPublicURL:     "",
+		envShutdownTimeout:      "",
 	}
 	envOptionals := []string{
 		envHealthchecksURL,
+		envModulesRulesDir,
+		envDataDir,
+		envClassifierThreshold,
+		envTransferAutoMerge,
+		envTransferDateWindow,
+		envDuplicateAction,
+		envQueueWorkers,
+		envQueueMaxAttempts,
+		envHTTPTimeout,
+		envHTTPMaxRetries,
+		envHTTPBreakerThreshold,
+		envHTTPBreakerCooldown,
+		envListenAddress,
+		envListenPort,
+		envWebhookPath,
+		envWebhookPublicURL,
+		envShutdownTimeout,
 	}
 
 	for envKey := range envMap {
@@ -46,6 +130,15 @@ func main() {
 		}
 		envMap[envKey] = envValue
 	}
+	if envMap[envDataDir] == "" {
+		envMap[envDataDir] = defaultDataDir
+	}
+	if envMap[envDuplicateAction] == "" {
+		envMap[envDuplicateAction] = worker.DuplicateActionNotify
+	}
+	if envMap[envWebhookPath] == "" {
+		envMap[envWebhookPath] = defaultWebhookPath
+	}
 
 	fireflyOptions := worker.FireflyOptions{
 		AccessToken: envMap[envAccessToken],
@@ -69,20 +162,99 @@ func main() {
 		log.Fatalf("could not parse environment variable %s = %s as int", envTelegramChatID, envMap[envTelegramChatID])
 	}
 	telegramOptions := worker.TelegramOptions{
-		AccessToken: envMap[envTelegramToken],
-		ChatID:      chatIDInt,
+		AccessToken:  envMap[envTelegramToken],
+		ChatID:       chatIDInt,
+		MessagesPath: filepath.Join(envMap[envDataDir], "notifications.json"),
+	}
+	moduleOptions := worker.ModuleOptions{
+		RulesDir:    envMap[envModulesRulesDir],
+		AliasesPath: filepath.Join(envMap[envDataDir], "payee-aliases.json"),
+		AuditPath:   filepath.Join(envMap[envDataDir], "audit.db"),
+	}
+	var classifierThreshold float64
+	if envMap[envClassifierThreshold] != "" {
+		classifierThreshold, err = strconv.ParseFloat(envMap[envClassifierThreshold], 64)
+		if err != nil || classifierThreshold <= 0 || classifierThreshold > 1 {
+			log.Fatalf("could not parse environment variable %s = %s as number between 0 and 1", envClassifierThreshold, envMap[envClassifierThreshold])
+		}
+	}
+	classifierOptions := worker.ClassifierOptions{
+		ModelPath:          filepath.Join(envMap[envDataDir], "classifier.json"),
+		AutoApplyThreshold: classifierThreshold,
+	}
+	transferOptions := worker.TransferOptions{DateWindowDays: defaultTransferDateWindow}
+	if envMap[envTransferAutoMerge] != "" {
+		transferOptions.AutoMerge, err = strconv.ParseBool(envMap[envTransferAutoMerge])
+		if err != nil {
+			log.Fatalf("could not parse environment variable %s = %s as bool", envTransferAutoMerge, envMap[envTransferAutoMerge])
+		}
+	}
+	if envMap[envTransferDateWindow] != "" {
+		transferOptions.DateWindowDays, err = strconv.Atoi(envMap[envTransferDateWindow])
+		if err != nil || transferOptions.DateWindowDays < 0 {
+			log.Fatalf("could not parse environment variable %s = %s as non-negative int", envTransferDateWindow, envMap[envTransferDateWindow])
+		}
+	}
+	duplicateActions := []string{worker.DuplicateActionOff, worker.DuplicateActionTag, worker.DuplicateActionNotify, worker.DuplicateActionDelete}
+	if !slices.Contains(duplicateActions, envMap[envDuplicateAction]) {
+		log.Fatalf("environment variable %s = %s must be one of %v", envDuplicateAction, envMap[envDuplicateAction], duplicateActions)
+	}
+	duplicateOptions := worker.DuplicateOptions{Action: envMap[envDuplicateAction]}
+	queueOptions := worker.QueueOptions{
+		Path:        filepath.Join(envMap[envDataDir], "queue.db"),
+		Workers:     envInt(envMap, envQueueWorkers, defaultQueueWorkers, 1),
+		MaxAttempts: envInt(envMap, envQueueMaxAttempts, defaultQueueMaxAttempts, 1),
+	}
+	httpOptions := worker.HTTPOptions{
+		Timeout:          time.Duration(envInt(envMap, envHTTPTimeout, defaultHTTPTimeout, 1)) * time.Second,
+		MaxRetries:       envInt(envMap, envHTTPMaxRetries, defaultHTTPMaxRetries, 0),
+		BreakerThreshold: envInt(envMap, envHTTPBreakerThreshold, defaultHTTPBreakerThreshold, 0),
+		BreakerCooldown:  time.Duration(envInt(envMap, envHTTPBreakerCooldown, defaultHTTPBreakerCooldown, 1)) * time.Second,
+	}
+	serverOptions := worker.ServerOptions{
+		BindAddress:     envMap[envListenAddress],
+		Port:            envInt(envMap, envListenPort, defaultListenPort, 1),
+		WebhookPath:     envMap[envWebhookPath],
+		PublicURL:       envMap[envWebhookPublicURL],
+		ShutdownTimeout: time.Duration(envInt(envMap, envShutdownTimeout, defaultShutdownTimeout, 1)) * time.Second,
+	}
+	if serverOptions.Port > 65535 {
+		log.Fatalf("environment variable %s = %d is no valid port", envListenPort, serverOptions.Port)
+	}
+	if !strings.HasPrefix(serverOptions.WebhookPath, "/") {
+		log.Fatalf("environment variable %s = %s must start with /", envWebhookPath, serverOptions.WebhookPath)
+	}
+	if serverOptions.PublicURL != "" {
+		publicURL, err := url.Parse(serverOptions.PublicURL)
+		if err != nil || (publicURL.Scheme != "http" && publicURL.Scheme != "https") || publicURL.Host == "" {
+			log.Fatalf("environment variable %s = %s must be an absolute http or https URL", envWebhookPublicURL, serverOptions.PublicURL)
+		}
 	}
 	log.Println("Running", version)
 	log.Println("//////////SETUP//////////")
 	log.Println()
-	w, err := worker.NewWorker(fireflyOptions, autoImportOptions, telegramOptions)
+	w, err := worker.NewWorker(fireflyOptions, autoImportOptions, telegramOptions, moduleOptions, classifierOptions, transferOptions, duplicateOptions, queueOptions, httpOptions, serverOptions)
 	if err != nil {
 		log.Fatalln(err)
 	}
 	log.Println()
 	log.Println("//////////START//////////")
 	log.Println()
-	if err := w.Listen(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := w.Listen(ctx); err != nil {
 		log.Fatalln(err)
 	}
 }
+
+// envInt returns the environment variable parsed as int of at least minValue, defaultValue if it is not set.
+func envInt(envMap map[string]string, envKey string, defaultValue int, minValue int) int {
+	if envMap[envKey] == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(envMap[envKey])
+	if err != nil || value < minValue {
+		log.Fatalf("could not parse environment variable %s = %s as int of at least %d", envKey, envMap[envKey], minValue)
+	}
+	return value
+}